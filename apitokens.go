@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	apiTokensFile       = "tokens.json"
+	apiTokenPrefix      = "wge_"
+	apiTokenSaveEvery   = time.Minute // last-used timestamps are persisted at most this often
+	maxAPITokenNameSize = 64
+)
+
+// TokenScope limits what an API token can do, on top of the owner's role.
+type TokenScope string
+
+const (
+	ScopeRead              TokenScope = "read"
+	ScopeClientsWrite      TokenScope = "clients:write"
+	ScopePortForwardsWrite TokenScope = "portforwards:write"
+)
+
+var scopePermissions = map[TokenScope][]Permission{
+	ScopeRead:              {PermViewClients, PermViewPortForwards},
+	ScopeClientsWrite:      {PermViewClients, PermCreateClients, PermEditClients, PermToggleClients, PermDeleteClients, PermDownloadConfigs},
+	ScopePortForwardsWrite: {PermViewPortForwards, PermManagePortForwards},
+}
+
+// TokenScopes lists the scopes in the order shown in the UI.
+var TokenScopes = []TokenScope{ScopeRead, ScopeClientsWrite, ScopePortForwardsWrite}
+
+// APIToken is a personal access token. Only the SHA-256 hash of the secret
+// is stored; the secret is shown once when the token is created.
+type APIToken struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Username  string       `json:"username"`
+	Scopes    []TokenScope `json:"scopes"`
+	Hash      string       `json:"hash,omitempty"`
+	Hint      string       `json:"hint"` // first characters of the secret, for recognition
+	CreatedAt time.Time    `json:"created_at"`
+	ExpiresAt time.Time    `json:"expires_at,omitzero"`
+	LastUsed  time.Time    `json:"last_used,omitzero"`
+}
+
+// Permissions returns the union of the token's scope permissions.
+func (t *APIToken) Permissions() []Permission {
+	perms := []Permission{}
+	for _, scope := range t.Scopes {
+		perms = append(perms, scopePermissions[scope]...)
+	}
+	return perms
+}
+
+// Expired reports whether the token has passed its expiry time.
+func (t *APIToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// Public returns a copy of the token without its hash.
+func (t *APIToken) Public() APIToken {
+	public := *t
+	public.Hash = ""
+	return public
+}
+
+// APITokenStore holds the API tokens, persisted to tokens.json in the state
+// directory.
+type APITokenStore struct {
+	config *Config
+	mu     sync.Mutex
+	tokens map[string]*APIToken // key: hash
+	saved  time.Time
+}
+
+func NewAPITokenStore(config *Config) *APITokenStore {
+	ts := &APITokenStore{
+		config: config,
+		tokens: make(map[string]*APIToken),
+	}
+
+	var tokens []*APIToken
+	if err := loadState(config, apiTokensFile, &tokens); err != nil {
+		slog.Warn("Failed to load API tokens", "error", err)
+	}
+	for _, token := range tokens {
+		ts.tokens[token.Hash] = token
+	}
+
+	return ts
+}
+
+// save must be called with ts.mu held.
+func (ts *APITokenStore) save() error {
+	tokens := make([]*APIToken, 0, len(ts.tokens))
+	for _, token := range ts.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	ts.saved = time.Now()
+	return saveState(ts.config, apiTokensFile, tokens)
+}
+
+func hashAPIToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a token for username and returns it together with the
+// secret.
+func (ts *APITokenStore) Create(username, name string, scopes []TokenScope, ttl time.Duration) (*APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPITokenNameSize {
+		return nil, "", fmt.Errorf("token name must be 1-%d characters", maxAPITokenNameSize)
+	}
+	if len(scopes) == 0 {
+		return nil, "", errors.New("select at least one scope")
+	}
+	for _, scope := range scopes {
+		if _, ok := scopePermissions[scope]; !ok {
+			return nil, "", fmt.Errorf("invalid scope %q", scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	secret := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, "", err
+	}
+
+	token := &APIToken{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Username:  username,
+		Scopes:    scopes,
+		Hash:      hashAPIToken(secret),
+		Hint:      secret[:len(apiTokenPrefix)+4],
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		token.ExpiresAt = token.CreatedAt.Add(ttl)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.tokens[token.Hash] = token
+	if err := ts.save(); err != nil {
+		delete(ts.tokens, token.Hash)
+		return nil, "", fmt.Errorf("failed to save API tokens: %v", err)
+	}
+	return token, secret, nil
+}
+
+// Authenticate looks up the token for a secret and records its use.
+func (ts *APITokenStore) Authenticate(secret string) (*APIToken, bool) {
+	if !strings.HasPrefix(secret, apiTokenPrefix) {
+		return nil, false
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	token, ok := ts.tokens[hashAPIToken(secret)]
+	now := time.Now()
+	if !ok || token.Expired(now) {
+		return nil, false
+	}
+
+	token.LastUsed = now
+	if now.Sub(ts.saved) > apiTokenSaveEvery {
+		if err := ts.save(); err != nil {
+			slog.Warn("Failed to save API tokens", "error", err)
+		}
+	}
+
+	public := token.Public()
+	return &public, true
+}
+
+// List returns the tokens of username, or all tokens if username is empty,
+// oldest first.
+func (ts *APITokenStore) List(username string) []APIToken {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	tokens := []APIToken{}
+	for _, token := range ts.tokens {
+		if username == "" || token.Username == username {
+			tokens = append(tokens, token.Public())
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].CreatedAt.Before(tokens[j].CreatedAt) })
+	return tokens
+}
+
+// Revoke deletes a token. Unless username is empty, only that user's tokens
+// can be revoked.
+func (ts *APITokenStore) Revoke(id, username string) (*APIToken, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	for hash, token := range ts.tokens {
+		if token.ID != id || (username != "" && token.Username != username) {
+			continue
+		}
+		delete(ts.tokens, hash)
+		if err := ts.save(); err != nil {
+			ts.tokens[hash] = token
+			return nil, fmt.Errorf("failed to save API tokens: %v", err)
+		}
+		return token, nil
+	}
+	return nil, errors.New("token not found")
+}
+
+// RevokeAll deletes all tokens of username, e.g. when the account is deleted.
+func (ts *APITokenStore) RevokeAll(username string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	removed := false
+	for hash, token := range ts.tokens {
+		if token.Username == username {
+			delete(ts.tokens, hash)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	return ts.save()
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Versioned JSON API (/api/v1). Requests and responses are JSON; errors are
+// {"error": "...", "code": "..."} with a matching status code.
+
+// decodeJSON decodes the request body into v, rejecting unknown fields. It
+// writes a 400 response and returns false on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return false
+	}
+	return true
+}
+
+// apiClient loads the {id} client for an API request, writing a 404 if it
+// does not exist or is not visible to the user.
+func (s *Server) apiClient(w http.ResponseWriter, r *http.Request) (*WireGuardClient, bool) {
+	client, err := s.requestClient(r)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, "client not found")
+		return nil, false
+	}
+	return client, true
+}
+
+type serverStatus struct {
+	Interface      string    `json:"interface"`
+	InterfaceUp    bool      `json:"interface_up"`
+	PublicKey      string    `json:"public_key"`
+	Endpoint       string    `json:"endpoint"`
+	AddressV4      string    `json:"address_v4"`
+	AddressV6      string    `json:"address_v6"`
+	Clients        int       `json:"clients"`
+	EnabledClients int       `json:"enabled_clients"`
+	PortForwarding bool      `json:"port_forwarding"`
+	PortMappings   int       `json:"port_mappings"`
+	StartedAt      time.Time `json:"started_at"`
+	Uptime         int64     `json:"uptime"` // seconds
+}
+
+func (s *Server) handleAPIv1Status(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	clients := s.visibleClients(user)
+
+	status := serverStatus{
+		Interface:      s.config.WgInterface,
+		PublicKey:      s.wg.getServerPublicKey(),
+		Endpoint:       s.settings.Current().WgEndpoint,
+		AddressV4:      s.config.WgAddressV4,
+		AddressV6:      s.config.WgAddressV6,
+		Clients:        len(clients),
+		PortForwarding: s.pf.IsEnabled(),
+		StartedAt:      s.started,
+		Uptime:         int64(time.Since(s.started).Seconds()),
+	}
+	if _, err := net.InterfaceByName(s.config.WgInterface); err == nil {
+		status.InterfaceUp = true
+	}
+	for _, client := range clients {
+		if client.Enabled {
+			status.EnabledClients++
+		}
+	}
+	if owned := s.ownedClientIPs(user); owned != nil {
+		for _, mapping := range s.pf.GetAllMappings() {
+			if owned[mapping.ClientIP] {
+				status.PortMappings++
+			}
+		}
+	} else {
+		status.PortMappings = len(s.pf.GetAllMappings())
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// Clients
+
+type createClientRequest struct {
+	Name       string   `json:"name"`
+	Owner      string   `json:"owner,omitempty"`
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+}
+
+type updateClientRequest struct {
+	Name       *string   `json:"name"`
+	Enabled    *bool     `json:"enabled"`
+	AllowedIPs *[]string `json:"allowed_ips"`
+}
+
+func (s *Server) handleAPIv1ListClients(w http.ResponseWriter, r *http.Request) {
+	s.handleAPIClients(w, r)
+}
+
+func (s *Server) handleAPIv1GetClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, clientView(currentUser(r), client))
+}
+
+func (s *Server) handleAPIv1CreateClient(w http.ResponseWriter, r *http.Request) {
+	var req createClientRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	user := currentUser(r)
+	client, err := s.createClientFor(user, req.Name, req.Owner, req.AllowedIPs)
+	if err != nil {
+		writeJSONError(w, clientErrorStatus(err), err.Error())
+		return
+	}
+	requestLogger(r).Info("Client created", "user", user.Username, "client_id", client.ID,
+		"client_name", client.Name, "client_ip", clientIPv4(client))
+	s.audit(r, "client.create", client.ID, nil, auditClient(client))
+
+	writeJSON(w, http.StatusCreated, clientView(user, client))
+}
+
+func (s *Server) handleAPIv1UpdateClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+
+	var req updateClientRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user := currentUser(r)
+	if (req.Name != nil || req.AllowedIPs != nil) && !user.Can(PermEditClients) {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("missing permission %s", PermEditClients))
+		return
+	}
+	if req.Enabled != nil && !user.Can(PermToggleClients) {
+		writeJSONError(w, http.StatusForbidden, fmt.Sprintf("missing permission %s", PermToggleClients))
+		return
+	}
+
+	// All fields are applied together or not at all
+	updated, err := s.wg.UpdateClient(client.ID, ClientChange{Name: req.Name, Enabled: req.Enabled, AllowedIPs: req.AllowedIPs})
+	if err != nil {
+		writeJSONError(w, clientErrorStatus(err), err.Error())
+		return
+	}
+	requestLogger(r).Info("Client updated", "user", user.Username, "client_id", client.ID)
+	s.audit(r, "client.update", client.ID, auditClient(client), auditClient(updated))
+	client = updated
+
+	writeJSON(w, http.StatusOK, clientView(user, client))
+}
+
+func (s *Server) handleAPIv1DeleteClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.wg.DeleteClient(client.ID); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	requestLogger(r).Info("Client deleted", "user", currentUser(r).Username, "client_id", client.ID, "client_ip", clientIPv4(client))
+	s.audit(r, "client.delete", client.ID, auditClient(client), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAPIv1RegenerateKeys(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+
+	updated, err := s.wg.RegenerateClientKeys(client.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	requestLogger(r).Info("Client keys regenerated", "user", currentUser(r).Username, "client_id", client.ID)
+	s.audit(r, "client.regenerate_keys", client.ID, auditClient(client), auditClient(updated))
+	client = updated
+
+	writeJSON(w, http.StatusOK, clientView(currentUser(r), client))
+}
+
+func (s *Server) handleAPIv1ClientConfig(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+
+	config := s.wg.GenerateClientConfig(client)
+	requestLogger(r).Info("Client config downloaded", "user", currentUser(r).Username, "client_id", client.ID)
+	s.audit(r, "client.download_config", client.ID, nil, nil)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", client.Name))
+	w.Write([]byte(config))
+}
+
+// Port forwards
+
+type createPortForwardRequest struct {
+	ExternalPort uint16 `json:"external_port,omitempty"` // 0: assign one
+	InternalPort uint16 `json:"internal_port"`
+	Protocol     string `json:"protocol"`
+	Lifetime     uint32 `json:"lifetime,omitempty"` // seconds, 0: port_forward_lifetime
+	Description  string `json:"description,omitempty"`
+}
+
+func (s *Server) handleAPIv1ListPortForwards(w http.ResponseWriter, r *http.Request) {
+	s.handleAPIAllPortForwards(w, r)
+}
+
+func (s *Server) handleAPIv1ClientPortForwards(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+
+	mappings := s.pf.GetClientMappings(clientIPv4(client))
+	if mappings == nil {
+		mappings = []*PortMapping{}
+	}
+	writeJSON(w, http.StatusOK, mappings)
+}
+
+func (s *Server) handleAPIv1CreatePortForward(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+	if !s.pf.IsEnabled() {
+		writeJSONError(w, http.StatusServiceUnavailable, "port forwarding is disabled")
+		return
+	}
+	if !client.Enabled {
+		writeJSONError(w, http.StatusConflict, "client is disabled")
+		return
+	}
+
+	var req createPortForwardRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Protocol != "tcp" && req.Protocol != "udp" {
+		writeJSONError(w, http.StatusBadRequest, `protocol must be "tcp" or "udp"`)
+		return
+	}
+	if req.InternalPort == 0 {
+		writeJSONError(w, http.StatusBadRequest, "internal_port is required")
+		return
+	}
+	if req.Lifetime == 0 {
+		req.Lifetime = uint32(s.settings.Current().PortForwardLifetime)
+	}
+	user := currentUser(r)
+	if req.Description == "" {
+		req.Description = "API (" + user.Username + ")"
+	}
+
+	clientIP := clientIPv4(client)
+	port, err := s.pf.addMapping(clientIP, req.ExternalPort, req.InternalPort, req.Protocol, req.Description, req.Lifetime)
+	switch {
+	case errors.Is(err, errPortReserved), errors.Is(err, errNoFreePort), errors.Is(err, errMappingLimit):
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	requestLogger(r).Info("Port forward added", "user", user.Username, "client_id", client.ID, "client_ip", clientIP,
+		"ext_port", port, "int_port", req.InternalPort, "protocol", req.Protocol)
+
+	for _, mapping := range s.pf.GetClientMappings(clientIP) {
+		if mapping.ExternalPort == port && mapping.Protocol == req.Protocol {
+			s.audit(r, "portforward.create", mappingTarget(mapping), nil, mapping)
+			writeJSON(w, http.StatusCreated, mapping)
+			return
+		}
+	}
+	writeJSONError(w, http.StatusInternalServerError, "mapping vanished after creation")
+}
+
+func (s *Server) handleAPIv1DeletePortForward(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.apiClient(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	port, err := strconv.ParseUint(vars["port"], 10, 16)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid port")
+		return
+	}
+
+	user := currentUser(r)
+	mapping, err := s.pf.removeMapping(clientIPv4(client), uint16(port), vars["protocol"], "removed by "+user.Username)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	requestLogger(r).Info("Port forward removed", "user", user.Username, "client_id", client.ID,
+		"client_ip", clientIPv4(client), "ext_port", port, "protocol", vars["protocol"])
+	s.audit(r, "portforward.delete", mappingTarget(mapping), mapping, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestServer returns a Server with three clients, one of them owned by
+// "alice", and port mappings for the first two. Nothing touches wg or
+// iptables.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	config := &Config{StateDir: t.TempDir(), WgInterface: "wg0", BasePath: "/wgeasy", SessionSecret: "test-secret",
+		WgAddressV4: "10.8.0.1/24", WgAddressV6: "fd42::1/64", WgEndpoint: "vpn.example.com:51820"}
+	settings := NewSettingsStore(config)
+	events := NewEventBus()
+
+	wg := NewWireGuardManager(config, settings, events)
+	wg.clients = map[string]*WireGuardClient{
+		"client-2": {ID: "client-2", Name: "laptop", PublicKey: "pub2", PrivateKey: "priv2", AddressV4: "10.8.0.2/32", AddressV6: "fd42::2/128",
+			CreatedAt: "2026-01-02T03:04:05Z", Enabled: true},
+		"client-3": {ID: "client-3", Name: "phone", PublicKey: "pub3", PrivateKey: "priv3", AddressV4: "10.8.0.3/32", AddressV6: "fd42::3/128",
+			CreatedAt: "2026-01-02T03:04:05Z", Enabled: true, Owner: "alice", AllowedIPs: []string{"10.0.0.0/8"}},
+		"client-4": {ID: "client-4", Name: "router", PublicKey: "pub4", PrivateKey: "priv4", AddressV4: "10.8.0.4/32", AddressV6: "fd42::4/128",
+			CreatedAt: "2026-01-02T03:04:05Z"},
+	}
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	pf := &PortForwardServer{config: config, settings: settings, mappings: map[string]*PortMapping{
+		"10.8.0.2:40000:tcp": {ClientIP: "10.8.0.2", ExternalPort: 40000, InternalPort: 22, Protocol: "tcp",
+			Lifetime: 3600, CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		"10.8.0.3:40001:udp": {ClientIP: "10.8.0.3", ExternalPort: 40001, InternalPort: 51413, Protocol: "udp", Description: "torrent",
+			Lifetime: 7200, CreatedAt: now, ExpiresAt: now.Add(2 * time.Hour), RenewalCount: 3, LastRenewed: now},
+	}}
+
+	return &Server{config: config, settings: settings, wg: wg, pf: pf, events: events}
+}
+
+// servedOpenAPI fetches the OpenAPI document the server serves.
+func servedOpenAPI(t *testing.T, s *Server) map[string]interface{} {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.handleAPIOpenAPI(rec, httptest.NewRequest("GET", "/wgeasy/api/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("openapi.json: status %d", rec.Code)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return doc
+}
+
+// responseSchema returns the schema documented for the operation's response
+// with the given status, or the shared Error schema for other statuses.
+func responseSchema(t *testing.T, doc map[string]interface{}, method, path string, status int) map[string]interface{} {
+	t.Helper()
+	operation, _ := dig(doc, "paths", path, strings.ToLower(method)).(map[string]interface{})
+	if operation == nil {
+		t.Fatalf("%s %s is not documented", method, path)
+	}
+	response, _ := dig(operation, "responses", fmt.Sprint(status)).(map[string]interface{})
+	if response == nil {
+		response, _ = dig(doc, "components", "responses", "Error").(map[string]interface{})
+	}
+	schema, _ := dig(response, "content", "application/json", "schema").(map[string]interface{})
+	if schema == nil {
+		t.Fatalf("%s %s: no JSON schema for status %d", method, path, status)
+	}
+	return schema
+}
+
+func dig(v interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// schemaProblems checks value against schema, resolving $refs in doc.
+// Objects may only contain documented properties.
+func schemaProblems(doc, schema map[string]interface{}, value interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, _ := dig(doc, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...).(map[string]interface{})
+		if resolved == nil {
+			return []string{fmt.Sprintf("%s: unresolved %s", at, ref)}
+		}
+		return schemaProblems(doc, resolved, value, at)
+	}
+	if value == nil {
+		if schema["nullable"] == true {
+			return nil
+		}
+		return []string{at + ": null"}
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		var problems []string
+		for _, sub := range allOf {
+			problems = append(problems, schemaProblems(doc, sub.(map[string]interface{}), value, at)...)
+		}
+		return problems
+	}
+
+	wrongType := func() []string {
+		return []string{fmt.Sprintf("%s: %T, want %v", at, value, schema["type"])}
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return wrongType()
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		var problems []string
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := object[name.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: missing %s", at, name))
+				}
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: undocumented property %s", at, name))
+				continue
+			}
+			problems = append(problems, schemaProblems(doc, property, object[name], at+"."+name)...)
+		}
+		return problems
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return wrongType()
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		var problems []string
+		for i, item := range array {
+			problems = append(problems, schemaProblems(doc, items, item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+		return problems
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return wrongType()
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok && !slices.Contains(enum, interface{}(s)) {
+			return []string{fmt.Sprintf("%s: %q not in %v", at, s, enum)}
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return []string{fmt.Sprintf("%s: %v", at, err)}
+			}
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			return wrongType()
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return wrongType()
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return wrongType()
+		}
+	}
+	return nil
+}
+
+func TestAPIResponsesMatchOpenAPI(t *testing.T) {
+	admin := &User{Username: "admin", Role: RoleAdmin, BuiltIn: true}
+	viewer := &User{Username: "bob", Role: RoleViewer}
+	alice := &User{Username: "alice", Role: RoleUser}
+
+	tests := []struct {
+		name       string
+		user       *User
+		method     string
+		path       string // documented path, with {param} placeholders
+		vars       map[string]string
+		body       string
+		handler    func(*Server) http.HandlerFunc
+		wantStatus int
+		wantLen    int // for array responses
+		wantBody   string
+	}{
+		{name: "list clients", user: admin, method: "GET", path: "/api/v1/clients",
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1ListClients }, wantStatus: http.StatusOK, wantLen: 3,
+			wantBody: `"private_key":"priv2"`},
+		{name: "list clients redacted", user: viewer, method: "GET", path: "/api/v1/clients",
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1ListClients }, wantStatus: http.StatusOK, wantLen: 3,
+			wantBody: `"private_key":""`},
+		{name: "list owned clients", user: alice, method: "GET", path: "/api/v1/clients",
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1ListClients }, wantStatus: http.StatusOK, wantLen: 1,
+			wantBody: `"allowed_ips":["10.0.0.0/8"]`},
+		{name: "get client", user: admin, method: "GET", path: "/api/v1/clients/{id}", vars: map[string]string{"id": "client-2"},
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1GetClient }, wantStatus: http.StatusOK},
+		{name: "get unowned client", user: alice, method: "GET", path: "/api/v1/clients/{id}", vars: map[string]string{"id": "client-2"},
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1GetClient }, wantStatus: http.StatusNotFound,
+			wantBody: `"code":"not_found"`},
+		{name: "rename client", user: admin, method: "PATCH", path: "/api/v1/clients/{id}", vars: map[string]string{"id": "client-2"},
+			body:    `{"name": "desktop", "allowed_ips": ["192.168.1.7/24"]}`,
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1UpdateClient }, wantStatus: http.StatusOK,
+			wantBody: `"allowed_ips":["192.168.1.0/24"]`},
+		{name: "invalid allowed IP", user: admin, method: "PATCH", path: "/api/v1/clients/{id}", vars: map[string]string{"id": "client-2"},
+			body:    `{"name": "desktop", "allowed_ips": ["10.0.0.300/8"]}`,
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1UpdateClient }, wantStatus: http.StatusBadRequest,
+			wantBody: `invalid allowed IP`},
+		{name: "create client with invalid allowed IP", user: admin, method: "POST", path: "/api/v1/clients",
+			body:    `{"name": "tablet", "allowed_ips": ["nope"]}`,
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1CreateClient }, wantStatus: http.StatusBadRequest,
+			wantBody: `invalid allowed IP`},
+		{name: "list port forwards", user: admin, method: "GET", path: "/api/v1/portforwards",
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1ListPortForwards }, wantStatus: http.StatusOK, wantLen: 2},
+		{name: "list owned port forwards", user: alice, method: "GET", path: "/api/v1/portforwards",
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1ListPortForwards }, wantStatus: http.StatusOK, wantLen: 1,
+			wantBody: `"description":"torrent"`},
+		{name: "list client port forwards", user: admin, method: "GET", path: "/api/v1/clients/{id}/portforwards", vars: map[string]string{"id": "client-2"},
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1ClientPortForwards }, wantStatus: http.StatusOK, wantLen: 1},
+		{name: "list port forwards of client without any", user: admin, method: "GET", path: "/api/v1/clients/{id}/portforwards", vars: map[string]string{"id": "client-4"},
+			handler: func(s *Server) http.HandlerFunc { return s.handleAPIv1ClientPortForwards }, wantStatus: http.StatusOK, wantBody: `[]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			doc := servedOpenAPI(t, s)
+
+			req := httptest.NewRequest(tt.method, "/wgeasy"+tt.path, strings.NewReader(tt.body))
+			req = mux.SetURLVars(req, tt.vars)
+			req = req.WithContext(context.WithValue(req.Context(), userContextKey, tt.user))
+			rec := httptest.NewRecorder()
+			tt.handler(s)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", rec.Body, tt.wantBody)
+			}
+
+			var body interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid JSON %q: %v", rec.Body, err)
+			}
+			if array, ok := body.([]interface{}); ok && len(array) != tt.wantLen {
+				t.Errorf("got %d items, want %d", len(array), tt.wantLen)
+			}
+			for _, problem := range schemaProblems(doc, responseSchema(t, doc, tt.method, tt.path, rec.Code), body, "body") {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	doc := servedOpenAPI(t, newTestServer(t))
+
+	tests := []struct {
+		schema string
+		value  interface{}
+	}{
+		{"WireGuardClient", WireGuardClient{}},
+		{"WireGuardClient", WireGuardClient{Owner: "alice", AllowedIPs: []string{"0.0.0.0/0"}}},
+		{"PortMapping", PortMapping{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schema, func(t *testing.T) {
+			schema, _ := dig(doc, "components", "schemas", tt.schema).(map[string]interface{})
+			if schema == nil {
+				t.Fatalf("schema %s is not served", tt.schema)
+			}
+			data, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var value interface{}
+			json.Unmarshal(data, &value)
+			for _, problem := range schemaProblems(doc, schema, value, tt.schema) {
+				t.Error(problem)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audit log of administrative actions: who did what to which client, user
+// or port forward, from where. Entries are appended to audit.jsonl in
+// state_dir, one JSON object per line, and only ever removed by retention.
+
+const auditFile = "audit.jsonl"
+
+// auditPruneInterval is how often entries past the retention are removed.
+const auditPruneInterval = 24 * time.Hour
+
+// Actors of entries not caused by a logged-in user
+const (
+	AuditActorNATPMP = "natpmp"
+	AuditActorSystem = "system"
+)
+
+// AuditEntry is one recorded action. Before and After hold the affected
+// object (without secrets) where there is one.
+type AuditEntry struct {
+	ID       uint64          `json:"id"`
+	Time     time.Time       `json:"time"`
+	Actor    string          `json:"actor"`
+	SourceIP string          `json:"source_ip,omitempty"`
+	Action   string          `json:"action"` // e.g. "client.create", "login.failure"
+	Target   string          `json:"target,omitempty"`
+	Details  string          `json:"details,omitempty"`
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+}
+
+// AuditFilter selects entries; empty fields match everything. Action and
+// Target match by prefix, so "client." finds all client actions.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f AuditFilter) matches(entry *AuditEntry) bool {
+	return (f.Actor == "" || entry.Actor == f.Actor) &&
+		(f.Action == "" || strings.HasPrefix(entry.Action, f.Action)) &&
+		(f.Target == "" || strings.HasPrefix(entry.Target, f.Target)) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until))
+}
+
+type AuditLog struct {
+	config  *Config
+	mu      sync.Mutex
+	entries []AuditEntry // oldest first
+	nextID  uint64
+	file    *os.File
+}
+
+func NewAuditLog(config *Config) *AuditLog {
+	a := &AuditLog{config: config}
+	if err := a.load(); err != nil {
+		slog.Warn("Failed to load audit log", "error", err)
+	}
+	a.mu.Lock()
+	a.prune()
+	a.mu.Unlock()
+	return a
+}
+
+func (a *AuditLog) path() string {
+	return filepath.Join(a.config.StateDir, auditFile)
+}
+
+func (a *AuditLog) load() error {
+	f, err := os.Open(a.path())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // e.g. a line cut short by a crash
+		}
+		a.entries = append(a.entries, entry)
+		if entry.ID > a.nextID {
+			a.nextID = entry.ID
+		}
+	}
+	return scanner.Err()
+}
+
+// Record assigns the entry an ID and time and appends it. It is a no-op on
+// a nil log.
+func (a *AuditLog) Record(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.nextID++
+	entry.ID = a.nextID
+	entry.Time = time.Now().UTC()
+	a.entries = append(a.entries, entry)
+
+	if err := a.append(&entry); err != nil {
+		slog.Warn("Failed to write audit log", "action", entry.Action, "error", err)
+	}
+}
+
+// append writes one entry to the file. Must be called with a.mu held.
+func (a *AuditLog) append(entry *AuditEntry) error {
+	if a.file == nil {
+		if err := os.MkdirAll(a.config.StateDir, 0700); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(a.path(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		a.file = f
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = a.file.Write(append(data, '\n'))
+	return err
+}
+
+// Query returns the matching entries newest first, skipping offset and
+// returning at most limit, along with the number of matches.
+func (a *AuditLog) Query(filter AuditFilter, offset, limit int) ([]AuditEntry, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]AuditEntry, 0)
+	total := 0
+	for i := len(a.entries) - 1; i >= 0; i-- {
+		if !filter.matches(&a.entries[i]) {
+			continue
+		}
+		if total >= offset && len(result) < limit {
+			result = append(result, a.entries[i])
+		}
+		total++
+	}
+	return result, total
+}
+
+// Run removes entries past the retention once a day until ctx is cancelled.
+func (a *AuditLog) Run(ctx context.Context) {
+	ticker := time.NewTicker(auditPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			a.prune()
+			a.mu.Unlock()
+		}
+	}
+}
+
+// prune drops entries older than audit_retention_days and beyond
+// audit_max_entries and rewrites the file if anything was dropped. Must be
+// called with a.mu held.
+func (a *AuditLog) prune() {
+	keep := 0
+	cutoff := time.Now().AddDate(0, 0, -a.config.AuditRetentionDays)
+	for keep < len(a.entries) && a.entries[keep].Time.Before(cutoff) {
+		keep++
+	}
+	if excess := len(a.entries) - keep - a.config.AuditMaxEntries; excess > 0 {
+		keep += excess
+	}
+	if keep == 0 {
+		return
+	}
+	a.entries = append([]AuditEntry(nil), a.entries[keep:]...)
+
+	if err := a.rewrite(); err != nil {
+		slog.Warn("Failed to prune audit log", "error", err)
+		return
+	}
+	slog.Info("Pruned audit log", "removed", keep, "remaining", len(a.entries))
+}
+
+// rewrite replaces the file with the retained entries. Must be called with
+// a.mu held.
+func (a *AuditLog) rewrite() error {
+	if err := os.MkdirAll(a.config.StateDir, 0700); err != nil {
+		return err
+	}
+
+	tmp := a.path() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i := range a.entries {
+		if err := encoder.Encode(&a.entries[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, a.path()); err != nil {
+		return err
+	}
+
+	// Reopen on the next write; the old descriptor points to the old file
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	return nil
+}
+
+// Close closes the file.
+func (a *AuditLog) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+}
+
+// auditJSON encodes an object for Before/After; nil stays empty.
+func auditJSON(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// audit records an action of the request's user.
+func (s *Server) audit(r *http.Request, action, target string, before, after interface{}) {
+	entry := AuditEntry{
+		SourceIP: s.clientIP(r),
+		Action:   action,
+		Target:   target,
+		Before:   auditJSON(before),
+		After:    auditJSON(after),
+	}
+	if user := currentUser(r); user != nil {
+		entry.Actor = user.Username
+	}
+	s.auditLog.Record(entry)
+}
+
+func clientToggleAction(enabled bool) string {
+	if enabled {
+		return "client.enable"
+	}
+	return "client.disable"
+}
+
+// auditLogin records a login attempt by username; result is "success",
+// "failure" or "locked".
+func (s *Server) auditLogin(r *http.Request, username, method, result, details string) {
+	s.auditLog.Record(AuditEntry{
+		Actor:    username,
+		SourceIP: s.clientIP(r),
+		Action:   "login." + result,
+		Details:  method + optionalDetails(details),
+	})
+}
+
+func optionalDetails(details string) string {
+	if details == "" {
+		return ""
+	}
+	return ": " + details
+}
+
+// recordMapping audits port forward changes made over NAT-PMP and by
+// expiry. Changes made in the UI or API are recorded by their handlers, and
+// renewals do not change anything worth auditing.
+func (a *AuditLog) recordMapping(event MappingEvent) {
+	entry := AuditEntry{
+		SourceIP: event.Mapping.ClientIP,
+		Target:   mappingTarget(&event.Mapping),
+	}
+	switch {
+	case event.Type == MappingCreated && event.Mapping.Description == natpmpDescription:
+		entry.Actor = AuditActorNATPMP
+		entry.Action = "portforward.create"
+		entry.After = auditJSON(event.Mapping)
+	case event.Type == MappingRemoved && event.Reason == natpmpRemoveReason:
+		entry.Actor = AuditActorNATPMP
+		entry.Action = "portforward.delete"
+		entry.Before = auditJSON(event.Mapping)
+	case event.Type == MappingExpired:
+		entry.Actor = AuditActorSystem
+		entry.SourceIP = ""
+		entry.Action = "portforward.expire"
+		entry.Before = auditJSON(event.Mapping)
+	default:
+		return
+	}
+	a.Record(entry)
+}
+
+// mappingTarget names a port forward in audit entries, e.g. "10.8.0.2 tcp/8080".
+func mappingTarget(mapping *PortMapping) string {
+	return fmt.Sprintf("%s %s/%d", mapping.ClientIP, mapping.Protocol, mapping.ExternalPort)
+}
+
+// auditClient, auditUser and auditToken strip secrets before an object is
+// recorded.
+func auditClient(client *WireGuardClient) interface{} {
+	if client == nil {
+		return nil
+	}
+	public := *client
+	public.PrivateKey = ""
+	return &public
+}
+
+func auditUser(user *User) interface{} {
+	if user == nil {
+		return nil
+	}
+	public := *user
+	public.PasswordHash = ""
+	return &public
+}
+
+func auditToken(token *APIToken) interface{} {
+	if token == nil {
+		return nil
+	}
+	public := *token
+	public.Hash = ""
+	return &public
+}
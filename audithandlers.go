@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Audit log viewer
+
+const (
+	auditPerPage    = 50
+	auditMaxPerPage = 500
+)
+
+type auditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	Total   int          `json:"total"`
+	Page    int          `json:"page"`
+	PerPage int          `json:"per_page"`
+}
+
+// parseAuditTime accepts RFC 3339 timestamps and plain dates. With
+// wholeDay, a date means the end of that day so "until" includes it.
+func parseAuditTime(value string, wholeDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if wholeDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use YYYY-MM-DD or RFC 3339", value)
+}
+
+// auditQuery reads the filter and page from the query string: actor,
+// action, target, since, until, page and per_page.
+func auditQuery(query url.Values) (AuditFilter, int, int, error) {
+	filter := AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Target: query.Get("target"),
+	}
+	var err error
+	if filter.Since, err = parseAuditTime(query.Get("since"), false); err != nil {
+		return filter, 0, 0, err
+	}
+	if filter.Until, err = parseAuditTime(query.Get("until"), true); err != nil {
+		return filter, 0, 0, err
+	}
+
+	page, perPage := 1, auditPerPage
+	if value := query.Get("page"); value != "" {
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			return filter, 0, 0, fmt.Errorf("invalid page %q", value)
+		}
+	}
+	if value := query.Get("per_page"); value != "" {
+		if perPage, err = strconv.Atoi(value); err != nil || perPage < 1 || perPage > auditMaxPerPage {
+			return filter, 0, 0, fmt.Errorf("per_page must be between 1 and %d", auditMaxPerPage)
+		}
+	}
+	return filter, page, perPage, nil
+}
+
+func (s *Server) handleAPIAudit(w http.ResponseWriter, r *http.Request) {
+	filter, page, perPage, err := auditQuery(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	entries, total := s.auditLog.Query(filter, (page-1)*perPage, perPage)
+	writeJSON(w, http.StatusOK, auditPage{Entries: entries, Total: total, Page: page, PerPage: perPage})
+}
+
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, page, perPage, err := auditQuery(query)
+	var result auditPage
+	if err == nil {
+		entries, total := s.auditLog.Query(filter, (page-1)*perPage, perPage)
+		result = auditPage{Entries: entries, Total: total, Page: page, PerPage: perPage}
+	}
+
+	// Links to other pages keep the filter
+	pageURL := func(page int) string {
+		values := url.Values{}
+		for _, key := range []string{"actor", "action", "target", "since", "until", "per_page"} {
+			if value := query.Get(key); value != "" {
+				values.Set(key, value)
+			}
+		}
+		values.Set("page", strconv.Itoa(page))
+		return "?" + values.Encode()
+	}
+
+	errorMsg := ""
+	if err != nil {
+		errorMsg = err.Error()
+	}
+
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Audit Log - WireGuard Easy</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1200px; margin: 0 auto; padding: 20px; }
+        h1 { color: #333; }
+        .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
+        .back { padding: 8px 16px; background: #6c757d; color: white; text-decoration: none; border-radius: 4px; }
+        .back:hover { background: #5a6268; }
+        .error { background: #f8d7da; color: #721c24; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #dc3545; }
+        .filter { background: #f8f9fa; padding: 15px; border-radius: 8px; margin-bottom: 20px; display: flex; gap: 10px; flex-wrap: wrap; align-items: center; }
+        .filter input { padding: 6px; border: 1px solid #ddd; border-radius: 4px; }
+        .empty { color: #666; }
+        table { width: 100%; border-collapse: collapse; background: white; box-shadow: 0 2px 4px rgba(0,0,0,0.1); margin-bottom: 20px; }
+        th, td { padding: 10px; text-align: left; border-bottom: 1px solid #ddd; vertical-align: top; }
+        th { background: #007bff; color: white; }
+        tr:hover { background: #f8f9fa; }
+        .btn { padding: 6px 12px; text-decoration: none; border-radius: 4px; border: none; cursor: pointer; font-size: 14px; background: #007bff; color: white; }
+        .code { font-family: monospace; font-size: 12px; color: #666; }
+        details pre { font-size: 11px; white-space: pre-wrap; word-break: break-all; max-width: 400px; }
+        .pages { display: flex; gap: 10px; align-items: center; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>📜 Audit Log</h1>
+        <a href="{{.BasePath}}/" class="back">← Back to Clients</a>
+    </div>
+
+    {{if .Error}}
+    <div class="error">{{.Error}}</div>
+    {{end}}
+
+    <form method="GET" action="{{.BasePath}}/audit" class="filter">
+        <input type="text" name="actor" placeholder="Actor" value="{{.Query.Get "actor"}}">
+        <input type="text" name="action" placeholder="Action, e.g. client." value="{{.Query.Get "action"}}">
+        <input type="text" name="target" placeholder="Target" value="{{.Query.Get "target"}}">
+        <label>From <input type="date" name="since" value="{{.Query.Get "since"}}"></label>
+        <label>To <input type="date" name="until" value="{{.Query.Get "until"}}"></label>
+        <button type="submit" class="btn">Filter</button>
+        <a href="{{.BasePath}}/audit">Reset</a>
+    </form>
+
+    {{if .Page.Entries}}
+    <table>
+        <thead>
+            <tr>
+                <th>Time</th>
+                <th>Actor</th>
+                <th>Source IP</th>
+                <th>Action</th>
+                <th>Target</th>
+                <th>Change</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Page.Entries}}
+            <tr>
+                <td>{{.Time.Local.Format "2006-01-02 15:04:05"}}</td>
+                <td>{{.Actor}}</td>
+                <td class="code">{{.SourceIP}}</td>
+                <td class="code">{{.Action}}</td>
+                <td>{{.Target}}{{if .Details}}<br><span class="code">{{.Details}}</span>{{end}}</td>
+                <td>
+                    {{if .Before}}<details><summary>Before</summary><pre>{{printf "%s" .Before}}</pre></details>{{end}}
+                    {{if .After}}<details><summary>After</summary><pre>{{printf "%s" .After}}</pre></details>{{end}}
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    <div class="pages">
+        {{if gt .Page.Page 1}}<a href="{{.BasePath}}/audit{{call .PageURL (sub .Page.Page 1)}}">← Newer</a>{{end}}
+        <span>Page {{.Page.Page}} of {{.Pages}} ({{.Page.Total}} entries)</span>
+        {{if lt .Page.Page .Pages}}<a href="{{.BasePath}}/audit{{call .PageURL (add .Page.Page 1)}}">Older →</a>{{end}}
+    </div>
+    {{else}}
+    <p class="empty">No matching entries.</p>
+    {{end}}
+</body>
+</html>`
+
+	pages := 1
+	if result.PerPage > 0 && result.Total > 0 {
+		pages = (result.Total + result.PerPage - 1) / result.PerPage
+	}
+
+	t := template.Must(template.New("audit").Funcs(template.FuncMap{
+		"add": func(a, b int) int { return a + b },
+		"sub": func(a, b int) int { return a - b },
+	}).Parse(tmpl))
+	t.Execute(w, map[string]interface{}{
+		"User":      currentUser(r),
+		"Page":      result,
+		"Pages":     pages,
+		"PageURL":   pageURL,
+		"Query":     query,
+		"Error":     errorMsg,
+		"BasePath":  s.basePath(r),
+		"CSRFToken": s.csrfToken(w, r),
+	})
+}
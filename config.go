@@ -1,63 +1,289 @@
 package main
 
 import (
-	"encoding/json"
+	"fmt"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
 )
 
 type Config struct {
-	AdminPassword           string `json:"admin_password"`
-	BasePath                string `json:"base_path"`
-	ListenAddr              string `json:"listen_addr"`
-	WgInterface             string `json:"wg_interface"`
-	WgAddressV4             string `json:"wg_address_v4"`
-	WgAddressV6             string `json:"wg_address_v6"`
-	WgPort                  int    `json:"wg_port"`
-	WgEndpoint              string `json:"wg_endpoint"`
-	SessionSecret           string `json:"session_secret"`
-	PortForwardEnabled      bool   `json:"port_forward_enabled"`
-	PortForwardMinPort      uint16 `json:"port_forward_min_port"`
-	PortForwardMaxPort      uint16 `json:"port_forward_max_port"`
-	PortForwardMaxPerClient int    `json:"port_forward_max_per_client"`
-	PortForwardLifetime     int    `json:"port_forward_lifetime"` // seconds
+	AdminUsername            string   `json:"admin_username"`      // built-in admin account
+	AdminPassword            string   `json:"admin_password"`      // plaintext, deprecated
+	AdminPasswordHash        string   `json:"admin_password_hash"` // Argon2id or bcrypt, see "hash-password"
+	UserClientQuota          int      `json:"user_client_quota"`   // max clients per self-service user
+	LoginMaxAttempts         int      `json:"login_max_attempts"`  // failed logins before backoff starts
+	LoginLockoutMax          int      `json:"login_lockout_max"`   // seconds, longest lockout
+	BasePath                 string   `json:"base_path"`
+	ListenAddr               string   `json:"listen_addr"`
+	WgInterface              string   `json:"wg_interface"`
+	WgAddressV4              string   `json:"wg_address_v4"`
+	WgAddressV6              string   `json:"wg_address_v6"`
+	WgPort                   int      `json:"wg_port"`
+	WgEndpoint               string   `json:"wg_endpoint"`
+	WgDNS                    []string `json:"wg_dns"`                  // DNS servers in client configs
+	WgAllowedIPs             []string `json:"wg_allowed_ips"`          // default AllowedIPs of client configs
+	WgMTU                    int      `json:"wg_mtu"`                  // client MTU; 0 leaves it to wg-quick
+	WgPersistentKeepalive    int      `json:"wg_persistent_keepalive"` // seconds, default 25; -1 disables
+	SessionSecret            string   `json:"session_secret"`
+	PortForwardEnabled       bool     `json:"port_forward_enabled"`
+	PortForwardMinPort       uint16   `json:"port_forward_min_port"`
+	PortForwardMaxPort       uint16   `json:"port_forward_max_port"`
+	PortForwardMaxPerClient  int      `json:"port_forward_max_per_client"`
+	PortForwardLifetime      int      `json:"port_forward_lifetime"`       // seconds
+	PortForwardAllocation    string   `json:"port_forward_allocation"`     // "preserve", "random" or "sequential"
+	PortForwardReservedPorts []string `json:"port_forward_reserved_ports"` // e.g. "22", "8000-8100/tcp"
+	StateDir                 string   `json:"state_dir"`
+	ShutdownTimeout          int      `json:"shutdown_timeout"`            // seconds
+	ShutdownKeepPortForwards bool     `json:"shutdown_keep_port_forwards"` // leave iptables rules in place on exit
+	ShutdownRemoveInterface  bool     `json:"shutdown_remove_interface"`   // run wg-quick down on exit
+	LogFormat                string   `json:"log_format"`                  // "text" (default) or "json"
+	LogLevel                 string   `json:"log_level"`                   // debug, info (default), warn or error
+	AuditRetentionDays       int      `json:"audit_retention_days"`        // default 90
+	AuditMaxEntries          int      `json:"audit_max_entries"`           // default 10000
+
+	// OpenID Connect single sign-on, enabled when OIDCIssuer is set
+	OIDCIssuer        string          `json:"oidc_issuer"`
+	OIDCClientID      string          `json:"oidc_client_id"`
+	OIDCClientSecret  string          `json:"oidc_client_secret"`  // empty for public clients (PKCE only)
+	OIDCScopes        []string        `json:"oidc_scopes"`         // default: openid profile email
+	OIDCRedirectURL   string          `json:"oidc_redirect_url"`   // default: derived from the request
+	OIDCUsernameClaim string          `json:"oidc_username_claim"` // default: preferred_username
+	OIDCRolesClaim    string          `json:"oidc_roles_claim"`    // default: groups
+	OIDCRoleMapping   map[string]Role `json:"oidc_role_mapping"`   // claim value -> role
+	OIDCDefaultRole   Role            `json:"oidc_default_role"`   // empty: refuse unmapped users
+
+	// Reverse proxy integration. Requests from TrustedProxies may set
+	// X-Forwarded-Proto/X-Forwarded-Prefix and, if ProxyAuthHeader is set,
+	// authenticate users via that header.
+	TrustedProxies        []string        `json:"trusted_proxies"`          // CIDRs or addresses
+	ProxyAuthHeader       string          `json:"proxy_auth_header"`        // e.g. Remote-User; empty disables
+	ProxyAuthGroupsHeader string          `json:"proxy_auth_groups_header"` // default: Remote-Groups
+	ProxyAuthRoleMapping  map[string]Role `json:"proxy_auth_role_mapping"`  // group -> role
+	ProxyAuthDefaultRole  Role            `json:"proxy_auth_default_role"`  // empty: refuse unmapped users
+
+	// Outgoing webhooks, signed and retried, see webhooks.go
+	Webhooks []WebhookConfig `json:"webhooks"`
+
+	// Prometheus metrics, served at <base_path>/metrics or, with
+	// MetricsListenAddr, at /metrics on a separate listener
+	MetricsEnabled    bool   `json:"metrics_enabled"`
+	MetricsListenAddr string `json:"metrics_listen_addr"` // e.g. 127.0.0.1:9586; empty: main listener
+	MetricsToken      string `json:"metrics_token"`       // required as bearer token if set
 }
 
+// Placeholders from config.example.json (and the former session_secret
+// default) that must be replaced before starting
+var (
+	adminPasswordPlaceholders = []string{"change-this-password"}
+	sessionSecretPlaceholders = []string{"change-this-to-random-string", "change-this-secret-key"}
+)
+
+// LoadConfig reads the config file at path, if path is not empty, applies
+// WG_EASY_* environment overrides, fills in defaults and validates the
+// result. All problems are reported together as a ConfigError.
 func LoadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
+	var config Config
+	var problems ConfigError
+
+	if path != "" {
+		fileProblems, err := decodeConfigFile(path, &config)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, fileProblems...)
 	}
+	problems = append(problems, applyConfigEnv(&config, os.Environ())...)
+	problems = append(problems, config.check()...)
 
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return &config, nil
+}
+
+// check fills in defaults and returns every problem with the config. It is
+// also run on the config with settings overrides applied, see settings.go.
+func (c *Config) check() []string {
+	var problems []string
+
+	if c.AdminPasswordHash != "" {
+		if err := ValidatePasswordHash(c.AdminPasswordHash); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid admin_password_hash: %v", err))
+		}
+	}
+	if slices.Contains(adminPasswordPlaceholders, c.AdminPassword) {
+		problems = append(problems, fmt.Sprintf("admin_password is still the placeholder %q, set admin_password_hash instead", c.AdminPassword))
+	}
+	if c.SessionSecret == "" {
+		problems = append(problems, "session_secret is required, set it to a long random string")
+	} else if slices.Contains(sessionSecretPlaceholders, c.SessionSecret) {
+		problems = append(problems, fmt.Sprintf("session_secret is still the placeholder %q, set it to a long random string", c.SessionSecret))
 	}
 
 	// Set defaults
-	if config.BasePath == "" {
-		config.BasePath = ""
+	if c.AdminUsername == "" {
+		c.AdminUsername = "admin"
 	}
-	if config.ListenAddr == "" {
-		config.ListenAddr = ":8080"
+	if c.UserClientQuota == 0 {
+		c.UserClientQuota = 3
 	}
-	if config.WgInterface == "" {
-		config.WgInterface = "wg0"
+	if c.LoginMaxAttempts == 0 {
+		c.LoginMaxAttempts = 5
 	}
-	if config.SessionSecret == "" {
-		config.SessionSecret = "change-this-secret-key"
+	if c.LoginLockoutMax == 0 {
+		c.LoginLockoutMax = 900 // 15 minutes
 	}
-	if config.PortForwardMinPort == 0 {
-		config.PortForwardMinPort = 1024
+	if c.ListenAddr == "" {
+		c.ListenAddr = ":8080"
 	}
-	if config.PortForwardMaxPort == 0 {
-		config.PortForwardMaxPort = 65535
+	if c.WgInterface == "" {
+		c.WgInterface = "wg0"
 	}
-	if config.PortForwardMaxPerClient == 0 {
-		config.PortForwardMaxPerClient = 10
+	if c.WgAddressV4 == "" {
+		problems = append(problems, "wg_address_v4 is required, e.g. 10.8.0.1/24")
+	} else if prefix, err := netip.ParsePrefix(c.WgAddressV4); err != nil || !prefix.Addr().Is4() {
+		problems = append(problems, fmt.Sprintf("wg_address_v4 %q is not an IPv4 address with prefix length, e.g. 10.8.0.1/24", c.WgAddressV4))
 	}
-	if config.PortForwardLifetime == 0 {
-		config.PortForwardLifetime = 3600 // 1 hour
+	if c.WgAddressV6 == "" {
+		problems = append(problems, "wg_address_v6 is required, e.g. fd00::1/64")
+	} else if prefix, err := netip.ParsePrefix(c.WgAddressV6); err != nil || !prefix.Addr().Is6() || prefix.Addr().Is4In6() {
+		problems = append(problems, fmt.Sprintf("wg_address_v6 %q is not an IPv6 address with prefix length, e.g. fd00::1/64", c.WgAddressV6))
 	}
-
-	return &config, nil
+	if len(c.WgDNS) == 0 {
+		c.WgDNS = []string{"1.1.1.1", "2606:4700:4700::1111"}
+	}
+	for _, dns := range c.WgDNS {
+		if _, err := netip.ParseAddr(dns); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid wg_dns entry %q, expected an IP address", dns))
+		}
+	}
+	if len(c.WgAllowedIPs) == 0 {
+		c.WgAllowedIPs = []string{"0.0.0.0/0", "::/0"}
+	}
+	for _, prefix := range c.WgAllowedIPs {
+		if _, err := netip.ParsePrefix(prefix); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid wg_allowed_ips entry %q, expected an address with prefix length", prefix))
+		}
+	}
+	if c.WgMTU != 0 && (c.WgMTU < 1280 || c.WgMTU > 9000) {
+		problems = append(problems, fmt.Sprintf("wg_mtu %d is out of range (1280-9000, or 0 for automatic)", c.WgMTU))
+	}
+	if c.WgPersistentKeepalive == 0 {
+		c.WgPersistentKeepalive = 25
+	}
+	if c.WgPersistentKeepalive < -1 || c.WgPersistentKeepalive > 65535 {
+		problems = append(problems, fmt.Sprintf("wg_persistent_keepalive %d is out of range (1-65535, or -1 to disable)", c.WgPersistentKeepalive))
+	}
+	if strings.TrimSpace(c.WgEndpoint) == "" {
+		problems = append(problems, "wg_endpoint is required, e.g. vpn.example.com:51820")
+	}
+	if c.PortForwardMinPort == 0 {
+		c.PortForwardMinPort = 1024
+	}
+	if c.PortForwardMaxPort == 0 {
+		c.PortForwardMaxPort = 65535
+	}
+	if c.PortForwardMinPort > c.PortForwardMaxPort {
+		problems = append(problems, fmt.Sprintf("port_forward_min_port (%d) is greater than port_forward_max_port (%d)",
+			c.PortForwardMinPort, c.PortForwardMaxPort))
+	}
+	if c.PortForwardMaxPerClient == 0 {
+		c.PortForwardMaxPerClient = 10
+	}
+	if c.PortForwardLifetime == 0 {
+		c.PortForwardLifetime = 3600 // 1 hour
+	}
+	if c.PortForwardMaxPerClient < 0 || c.PortForwardLifetime < 0 {
+		problems = append(problems, "port_forward_max_per_client and port_forward_lifetime must be positive")
+	}
+	if c.PortForwardAllocation == "" {
+		c.PortForwardAllocation = AllocationPreserve
+	}
+	switch c.PortForwardAllocation {
+	case AllocationPreserve, AllocationRandom, AllocationSequential:
+	default:
+		problems = append(problems, fmt.Sprintf("invalid port_forward_allocation %q", c.PortForwardAllocation))
+	}
+	for _, spec := range c.PortForwardReservedPorts {
+		if _, _, _, err := parsePortSpec(spec); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid port_forward_reserved_ports entry %q: %v", spec, err))
+		}
+	}
+	if c.StateDir == "" {
+		c.StateDir = "/etc/wireguard"
+	}
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 10
+	}
+	if c.LogFormat == "" {
+		c.LogFormat = LogFormatText
+	}
+	if c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		problems = append(problems, fmt.Sprintf("invalid log_format %q (text or json)", c.LogFormat))
+	}
+	if c.AuditRetentionDays == 0 {
+		c.AuditRetentionDays = 90
+	}
+	if c.AuditMaxEntries == 0 {
+		c.AuditMaxEntries = 10000
+	}
+	if c.AuditRetentionDays < 0 || c.AuditMaxEntries < 0 {
+		problems = append(problems, "audit_retention_days and audit_max_entries must be positive")
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.OIDCIssuer != "" {
+		if c.OIDCClientID == "" {
+			problems = append(problems, "oidc_client_id is required when oidc_issuer is set")
+		}
+		if len(c.OIDCScopes) == 0 {
+			c.OIDCScopes = []string{"openid", "profile", "email"}
+		}
+		if c.OIDCUsernameClaim == "" {
+			c.OIDCUsernameClaim = "preferred_username"
+		}
+		if c.OIDCRolesClaim == "" {
+			c.OIDCRolesClaim = "groups"
+		}
+		for _, value := range sortedKeys(c.OIDCRoleMapping) {
+			if role := c.OIDCRoleMapping[value]; !role.Valid() {
+				problems = append(problems, fmt.Sprintf("invalid role %q in oidc_role_mapping for %q", role, value))
+			}
+		}
+		if c.OIDCDefaultRole != "" && !c.OIDCDefaultRole.Valid() {
+			problems = append(problems, fmt.Sprintf("invalid oidc_default_role %q", c.OIDCDefaultRole))
+		}
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.ProxyAuthHeader != "" {
+		if len(c.TrustedProxies) == 0 {
+			problems = append(problems, "trusted_proxies is required when proxy_auth_header is set")
+		}
+		if c.ProxyAuthGroupsHeader == "" {
+			c.ProxyAuthGroupsHeader = "Remote-Groups"
+		}
+		for _, group := range sortedKeys(c.ProxyAuthRoleMapping) {
+			if role := c.ProxyAuthRoleMapping[group]; !role.Valid() {
+				problems = append(problems, fmt.Sprintf("invalid role %q in proxy_auth_role_mapping for %q", role, group))
+			}
+		}
+		if c.ProxyAuthDefaultRole != "" && !c.ProxyAuthDefaultRole.Valid() {
+			problems = append(problems, fmt.Sprintf("invalid proxy_auth_default_role %q", c.ProxyAuthDefaultRole))
+		}
+	}
+	if err := validateWebhooks(c.Webhooks); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.MetricsListenAddr != "" && c.MetricsListenAddr == c.ListenAddr {
+		problems = append(problems, "metrics_listen_addr must differ from listen_addr")
+	}
+	return problems
 }
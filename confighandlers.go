@@ -0,0 +1,173 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"reflect"
+	"slices"
+)
+
+// Configuration page: the server settings form and a reload button
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	s.renderConfig(w, r, nil, "", "")
+}
+
+func (s *Server) handleReloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := s.reloader.Reload()
+	if err != nil {
+		s.renderConfig(w, r, nil, "Reload failed, the running configuration is unchanged.\n"+err.Error(), "")
+		return
+	}
+	s.audit(r, "config.reload", "", nil, result)
+
+	s.renderConfig(w, r, result, "", "")
+}
+
+func (s *Server) handleAPIReloadConfig(w http.ResponseWriter, r *http.Request) {
+	result, err := s.reloader.Reload()
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.audit(r, "config.reload", "", nil, result)
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) renderConfig(w http.ResponseWriter, r *http.Request, result *ReloadResult, errorMsg, success string) {
+	current := reflect.ValueOf(settingsOf(s.settings.Current()))
+	base := reflect.ValueOf(settingsOf(s.config.Snapshot()))
+	overridden := s.settings.Overridden()
+	keys := settingsKeys()
+
+	rows := make([]settingRow, 0, len(settingFields))
+	for _, field := range settingFields {
+		row := settingRow{
+			Key:         field.Key,
+			Label:       field.Label,
+			Hint:        field.Hint,
+			Options:     field.Options,
+			ConfigValue: formatSetting(base.Field(keys[field.Key]).Interface()),
+		}
+		if slices.Contains(overridden, field.Key) {
+			row.Value = formatSetting(current.Field(keys[field.Key]).Interface())
+		}
+		rows = append(rows, row)
+	}
+
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Configuration - WireGuard Easy</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1200px; margin: 0 auto; padding: 20px; }
+        h1 { color: #333; }
+        h2 { color: #555; margin-top: 30px; }
+        .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
+        .back { padding: 8px 16px; background: #6c757d; color: white; text-decoration: none; border-radius: 4px; }
+        .back:hover { background: #5a6268; }
+        .error { background: #f8d7da; color: #721c24; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #dc3545; white-space: pre-wrap; }
+        .success { background: #d4edda; color: #155724; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #28a745; }
+        .warning { background: #fff3cd; color: #856404; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #ffc107; }
+        table { width: 100%; border-collapse: collapse; background: white; box-shadow: 0 2px 4px rgba(0,0,0,0.1); margin-bottom: 20px; }
+        th, td { padding: 12px; text-align: left; border-bottom: 1px solid #ddd; }
+        th { background: #007bff; color: white; }
+        input[type="text"], select { width: 100%; padding: 6px; box-sizing: border-box; }
+        .btn { padding: 8px 16px; border-radius: 4px; border: none; cursor: pointer; font-size: 14px; background: #007bff; color: white; }
+        .btn:hover { background: #0056b3; }
+        .code { font-family: monospace; }
+        .hint { color: #666; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>⚙️ Configuration</h1>
+        <a href="{{.BasePath}}/" class="back">← Back to Clients</a>
+    </div>
+
+    {{if .Error}}
+    <div class="error">{{.Error}}</div>
+    {{end}}
+    {{if .Success}}
+    <div class="success">{{.Success}}</div>
+    {{end}}
+    {{with .Result}}
+    <div class="success">Configuration reloaded.
+        {{if .Applied}}Applied: {{range $i, $key := .Applied}}{{if $i}}, {{end}}<span class="code">{{$key}}</span>{{end}}{{else}}No live settings changed.{{end}}
+    </div>
+    {{if .Overridden}}
+    <div class="warning">These changes are hidden by the settings below, clear them to use the config values:
+        {{range $i, $key := .Overridden}}{{if $i}}, {{end}}<span class="code">{{$key}}</span>{{end}}
+    </div>
+    {{end}}
+    {{if .RestartRequired}}
+    <div class="warning">These changes take effect after a restart:
+        {{range $i, $key := .RestartRequired}}{{if $i}}, {{end}}<span class="code">{{$key}}</span>{{end}}
+    </div>
+    {{end}}
+    {{end}}
+
+    <h2>Server Settings</h2>
+    <p class="hint">Settings take effect immediately and override the config file. Leave a field empty to use the config value.</p>
+    <form method="POST" action="{{.BasePath}}/config">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <table>
+            <thead>
+                <tr><th>Setting</th><th>Value</th><th>Config value</th></tr>
+            </thead>
+            <tbody>
+                {{range .Rows}}
+                <tr>
+                    <td>{{.Label}}<br><span class="code hint">{{.Key}}</span></td>
+                    <td>
+                        {{if .Options}}
+                        <select name="{{.Key}}">
+                            <option value="">(config value)</option>
+                            {{$value := .Value}}
+                            {{range .Options}}<option value="{{.}}"{{if eq . $value}} selected{{end}}>{{.}}</option>{{end}}
+                        </select>
+                        {{else}}
+                        <input type="text" name="{{.Key}}" value="{{.Value}}" placeholder="{{.ConfigValue}}">
+                        {{end}}
+                        {{if .Hint}}<span class="hint">{{.Hint}}</span>{{end}}
+                    </td>
+                    <td>{{.ConfigValue}}</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+        <button type="submit" class="btn">💾 Save settings</button>
+    </form>
+
+    <h2>Configuration File</h2>
+    <p class="hint">The server settings above, and these, are applied on reload without a restart. Edit the config file or environment, then reload here or send SIGHUP.</p>
+    <table>
+        <thead>
+            <tr><th>Setting</th><th>Value</th></tr>
+        </thead>
+        <tbody>
+            <tr><td class="code">user_client_quota</td><td>{{.Config.UserClientQuota}}</td></tr>
+            <tr><td class="code">log_level</td><td>{{.Config.LogLevel}}</td></tr>
+        </tbody>
+    </table>
+
+    <form method="POST" action="{{.BasePath}}/config/reload">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit" class="btn">🔄 Reload configuration</button>
+    </form>
+</body>
+</html>`
+
+	t := template.Must(template.New("config").Parse(tmpl))
+	t.Execute(w, map[string]interface{}{
+		"User":      currentUser(r),
+		"Rows":      rows,
+		"Config":    s.config.Snapshot(),
+		"Result":    result,
+		"Error":     errorMsg,
+		"Success":   success,
+		"BasePath":  s.basePath(r),
+		"CSRFToken": s.csrfToken(w, r),
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config sources: a JSON or YAML file, overridden by WG_EASY_* environment
+// variables. Both are strict; unknown keys are reported instead of ignored.
+
+// configEnvPrefix names the environment variable of each config key, e.g.
+// WG_EASY_WG_ENDPOINT for wg_endpoint.
+const configEnvPrefix = "WG_EASY_"
+
+// removedConfigKeys explains what replaced a key that no longer exists.
+var removedConfigKeys = map[string]string{
+	"port_forward_webhook_url": `add a "webhooks" entry with events ["mapping.*"] instead`,
+}
+
+// unknownKeyProblem reports an unknown key, pointing at its replacement if
+// the key was removed.
+// findConfigFile returns the config file named in args, else config.json if
+// it exists, else "" for a config from the environment alone.
+func findConfigFile(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	if _, err := os.Stat("config.json"); os.IsNotExist(err) {
+		return ""
+	}
+	return "config.json"
+}
+
+func unknownKeyProblem(key, source string) string {
+	if hint, ok := removedConfigKeys[key]; ok {
+		return fmt.Sprintf("%s in %s is no longer supported, %s", key, source, hint)
+	}
+	return fmt.Sprintf("unknown key %q in %s", key, source)
+}
+
+// ConfigError lists every problem found while loading the config.
+type ConfigError []string
+
+func (e ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e, "\n  - ")
+}
+
+// configKeys maps each config key to the index of its Config field.
+func configKeys() map[string]int {
+	return jsonKeys(reflect.TypeOf(Config{}))
+}
+
+// jsonKeys maps the json names of a struct's fields to their index.
+func jsonKeys(t reflect.Type) map[string]int {
+	keys := make(map[string]int, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		keys[name] = i
+	}
+	return keys
+}
+
+// decodeConfigFile reads a .json, .yaml or .yml file into config. Unknown
+// keys and values of the wrong type are returned as problems; an unreadable
+// or malformed file is an error.
+func decodeConfigFile(path string, config *Config) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// YAML is converted to JSON so both are decoded by the json tags
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if data, err = json.Marshal(doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return decodeConfigValues(raw, config, path), nil
+}
+
+// decodeConfigValues decodes each key of raw into its config field, replacing
+// the previous value. source names where raw came from in problems.
+func decodeConfigValues(raw map[string]json.RawMessage, config *Config, source string) []string {
+	var problems []string
+	keys := configKeys()
+	v := reflect.ValueOf(config).Elem()
+	for _, key := range sortedKeys(raw) {
+		index, ok := keys[key]
+		if !ok {
+			problems = append(problems, unknownKeyProblem(key, source))
+			continue
+		}
+		// Decoding into an existing slice or map would write into memory
+		// shared with other copies of the config
+		field := v.Field(index)
+		field.Set(reflect.Zero(field.Type()))
+		decoder := json.NewDecoder(bytes.NewReader(raw[key]))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(field.Addr().Interface()); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	return problems
+}
+
+// applyConfigEnv overrides config with the WG_EASY_* variables in environ.
+func applyConfigEnv(config *Config, environ []string) []string {
+	var problems []string
+	keys := configKeys()
+	v := reflect.ValueOf(config).Elem()
+
+	sort.Strings(environ)
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, configEnvPrefix) {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, configEnvPrefix))
+		index, ok := keys[key]
+		if !ok {
+			if _, removed := removedConfigKeys[key]; removed {
+				problems = append(problems, unknownKeyProblem(key, "the environment ("+name+")"))
+			} else {
+				problems = append(problems, fmt.Sprintf("unknown environment variable %s", name))
+			}
+			continue
+		}
+		if err := setConfigValue(v.Field(index), value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	return problems
+}
+
+// setConfigValue parses an environment variable into a config field. Lists
+// are comma-separated ("openid,profile"), maps are comma-separated
+// key=value pairs ("admins=admin,ops=operator"); both, and any other type,
+// also accept JSON.
+func setConfigValue(field reflect.Value, value string) error {
+	t := field.Type()
+	switch t.Kind() {
+	case reflect.String:
+		field.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		field.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, t.Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetInt(n)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, t.Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		field.SetUint(n)
+		return nil
+	}
+
+	trimmed := strings.TrimSpace(value)
+	isJSON := strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{")
+	switch {
+	case !isJSON && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		list := reflect.MakeSlice(t, 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = reflect.Append(list, reflect.ValueOf(item).Convert(t.Elem()))
+			}
+		}
+		field.Set(list)
+		return nil
+	case !isJSON && t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String:
+		m := reflect.MakeMap(t)
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("invalid entry %q, expected key=value", pair)
+			}
+			m.SetMapIndex(reflect.ValueOf(strings.TrimSpace(k)).Convert(t.Key()), reflect.ValueOf(strings.TrimSpace(v)).Convert(t.Elem()))
+		}
+		field.Set(m)
+		return nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.DisallowUnknownFields()
+	target := reflect.New(t)
+	if err := decoder.Decode(target.Interface()); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+	field.Set(target.Elem())
+	return nil
+}
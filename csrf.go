@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+)
+
+const csrfSessionKey = "csrf_token"
+
+// csrfToken returns the synchronizer token of the session, creating it on
+// first use. It must be called before the response body is written, as a
+// new token is saved to the session cookie.
+func (s *Server) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	session, _ := s.store.Get(r, "session")
+	if token, ok := session.Values[csrfSessionKey].(string); ok && token != "" {
+		return token
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		requestLogger(r).Warn("Failed to generate CSRF token", "error", err)
+		return ""
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	session.Values[csrfSessionKey] = token
+	if err := session.Save(r, w); err != nil {
+		requestLogger(r).Warn("Failed to save CSRF token", "error", err)
+	}
+	return token
+}
+
+// csrfProtect rejects state-changing requests whose csrf_token form field
+// or X-CSRF-Token header does not match the session's token. Requests with
+// an API token are exempt.
+func (s *Server) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET", "HEAD", "OPTIONS":
+			next.ServeHTTP(w, r)
+			return
+		}
+		// Token-authenticated requests never use the session cookie
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, _ := s.store.Get(r, "session")
+		expected, _ := session.Values[csrfSessionKey].(string)
+		token := r.Header.Get("X-CSRF-Token")
+		if token == "" {
+			token = r.PostFormValue("csrf_token")
+		}
+
+		if expected == "" || !constantTimeEqual(token, expected) {
+			requestLogger(r).Warn("CSRF check failed", "method", r.Method, "path", r.URL.Path, "remote_ip", s.clientIP(r))
+			http.Error(w, "Forbidden - invalid or missing CSRF token, please reload the page", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleAPICSRFToken returns the session's CSRF token for API clients using
+// cookie authentication.
+func (s *Server) handleAPICSRFToken(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, csrfTokenResponse{CSRFToken: s.csrfToken(w, r)})
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Internal event bus. WireGuardManager and PortForwardServer publish changes,
+// /api/events streams them to browsers and scripts.
+
+type EventType string
+
+const (
+	EventClientCreated     EventType = "client.created"
+	EventClientDeleted     EventType = "client.deleted"
+	EventClientEnabled     EventType = "client.enabled"
+	EventClientDisabled    EventType = "client.disabled"
+	EventClientUpdated     EventType = "client.updated" // renamed, allowed IPs or keys changed
+	EventPeerHandshake     EventType = "peer.handshake"
+	EventPeerOnline        EventType = "peer.online"
+	EventPeerOffline       EventType = "peer.offline"
+	EventMappingCreated    EventType = "mapping.created"
+	EventMappingRenewed    EventType = "mapping.renewed"
+	EventMappingExpired    EventType = "mapping.expired"
+	EventMappingRemoved    EventType = "mapping.removed"
+	EventExternalIPChanged EventType = "server.external_ip_changed"
+)
+
+// EventTypes lists the types published on the bus.
+var EventTypes = []EventType{
+	EventClientCreated, EventClientDeleted, EventClientEnabled, EventClientDisabled, EventClientUpdated,
+	EventPeerHandshake, EventPeerOnline, EventPeerOffline,
+	EventMappingCreated, EventMappingRenewed, EventMappingExpired, EventMappingRemoved,
+	EventExternalIPChanged,
+}
+
+const (
+	eventHistory          = 100 // replayed to reconnecting subscribers
+	eventSubscriberBuffer = 64
+)
+
+// Event is one change. ClientID, Owner and ClientIP identify the affected
+// client where there is one and are used to filter events per user.
+type Event struct {
+	ID       uint64      `json:"id"`
+	Type     EventType   `json:"type"`
+	Time     time.Time   `json:"time"`
+	ClientID string      `json:"client_id,omitempty"`
+	ClientIP string      `json:"client_ip,omitempty"`
+	Owner    string      `json:"-"`
+	Data     interface{} `json:"data,omitempty"`
+}
+
+// EventBus fans events out to subscribers. Publishing never blocks: a
+// subscriber that falls behind by more than its buffer is dropped and has to
+// reconnect.
+type EventBus struct {
+	mu          sync.Mutex
+	nextID      uint64
+	history     []Event
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Publish assigns the event an ID and time and delivers it. It is a no-op on
+// a nil bus.
+func (b *EventBus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.nextID++
+	event.ID = b.nextID
+	event.Time = time.Now()
+
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistory {
+		b.history = b.history[len(b.history)-eventHistory:]
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns a channel of events published from now on, preceded by
+// the retained events with an ID above lastID (0: none). The channel is
+// closed when the subscriber falls behind or the bus is closed; cancel
+// unsubscribes.
+func (b *EventBus) Subscribe(lastID uint64) (events <-chan Event, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, eventSubscriberBuffer+eventHistory)
+	if lastID > 0 {
+		for _, event := range b.history {
+			if event.ID > lastID {
+				ch <- event
+			}
+		}
+	}
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Closed reports whether Close was called.
+func (b *EventBus) Closed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closed
+}
+
+// Close ends all subscriptions, e.g. so streaming requests finish on
+// shutdown.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// eventKeepalive is how often an idle stream gets a comment line, so proxies
+// do not close it.
+const eventKeepalive = 30 * time.Second
+
+// handleAPIEvents streams events as Server-Sent Events. Each event is sent
+// with its type as the SSE event name and its ID, so reconnecting clients
+// get missed events via Last-Event-ID.
+func (s *Server) handleAPIEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	user := currentUser(r)
+	lastID, _ := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64)
+	events, cancel := s.events.Subscribe(lastID)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !s.canSeeEvent(user, event) {
+				continue
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// canSeeEvent applies the user's permissions: mapping events need the port
+// forward permission, and self-service users only see their own clients.
+func (s *Server) canSeeEvent(user *User, event Event) bool {
+	if strings.HasPrefix(string(event.Type), "mapping.") && !user.Can(PermViewPortForwards) {
+		return false
+	}
+	if !user.OwnClientsOnly() || (event.ClientID == "" && event.ClientIP == "") {
+		return true
+	}
+	if event.Owner != "" {
+		return event.Owner == user.Username
+	}
+	return s.ownedClientIPs(user)[event.ClientIP]
+}
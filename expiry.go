@@ -0,0 +1,108 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"log/slog"
+	"time"
+)
+
+// expiryEntry schedules the removal of the mapping stored under key. Entries
+// are never updated in place: a renewal pushes a new entry and the old one is
+// skipped once it no longer matches the mapping's ExpiresAt.
+type expiryEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// expiryQueue is a min-heap of expiry entries ordered by expiresAt.
+type expiryQueue []expiryEntry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].expiresAt.Before(q[j].expiresAt) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiryEntry)) }
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// scheduleExpiry must be called with pfs.mu held.
+func (pfs *PortForwardServer) scheduleExpiry(key string, expiresAt time.Time) {
+	heap.Push(&pfs.expiry, expiryEntry{key: key, expiresAt: expiresAt})
+
+	// Wake the scheduler if this is now the earliest deadline
+	if pfs.expiry[0].key == key && pfs.expiry[0].expiresAt.Equal(expiresAt) {
+		select {
+		case pfs.expiryWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// runExpiryScheduler removes mappings at their exact ExpiresAt until ctx is
+// cancelled.
+func (pfs *PortForwardServer) runExpiryScheduler(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		pfs.mu.RLock()
+		wait := time.Hour
+		if len(pfs.expiry) > 0 {
+			wait = time.Until(pfs.expiry[0].expiresAt)
+		}
+		pfs.mu.RUnlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-pfs.expiryWake:
+		case <-timer.C:
+			pfs.expireDueMappings()
+		}
+	}
+}
+
+// expireDueMappings removes every mapping whose deadline has passed. The
+// mapping lock is only held to update the table; iptables runs afterwards.
+func (pfs *PortForwardServer) expireDueMappings() {
+	now := time.Now()
+	var expired []*PortMapping
+	var events []MappingEvent
+
+	pfs.mu.Lock()
+	for len(pfs.expiry) > 0 && !pfs.expiry[0].expiresAt.After(now) {
+		entry := heap.Pop(&pfs.expiry).(expiryEntry)
+		mapping, exists := pfs.mappings[entry.key]
+		if !exists || !mapping.ExpiresAt.Equal(entry.expiresAt) {
+			continue // Removed or renewed since scheduling
+		}
+		delete(pfs.mappings, entry.key)
+		events = append(events, newMappingEvent(MappingExpired, mapping, "not renewed"))
+		expired = append(expired, mapping)
+	}
+	if len(expired) > 0 {
+		pfs.saveMappings()
+	}
+	pfs.mu.Unlock()
+
+	pfs.events.Record(events...)
+	for _, mapping := range expired {
+		slog.Info("Port mapping expired", "client_ip", mapping.ClientIP, "ext_port", mapping.ExternalPort,
+			"int_port", mapping.InternalPort, "protocol", mapping.Protocol)
+		pfs.removeIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol)
+	}
+}
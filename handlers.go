@@ -1,26 +1,44 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
+	"github.com/skip2/go-qrcode"
 )
 
 type Server struct {
-	config *Config
-	wg     *WireGuardManager
-	pf     *PortForwardServer
-	store  *sessions.CookieStore
-	tmpl   *template.Template
+	config   *Config
+	settings *SettingsStore
+	wg       *WireGuardManager
+	pf       *PortForwardServer
+	users    *UserStore
+	oidc     *OIDCProvider
+	totp     *TOTPStore
+	limiter  *LoginLimiter
+	tokens   *APITokenStore
+	events   *EventBus
+	webhooks *WebhookDispatcher
+	auditLog *AuditLog
+	reloader *ConfigReloader
+	store    *sessions.CookieStore
+	tmpl     *template.Template
+
+	trustedProxies []netip.Prefix
+	started        time.Time
 }
 
-func NewServer(config *Config, wg *WireGuardManager, pf *PortForwardServer) *Server {
+func NewServer(config *Config, settings *SettingsStore, wg *WireGuardManager, pf *PortForwardServer, events *EventBus, webhooks *WebhookDispatcher, auditLog *AuditLog, reloader *ConfigReloader) *Server {
 	store := sessions.NewCookieStore([]byte(config.SessionSecret))
 	store.Options = &sessions.Options{
 		Path:     config.BasePath + "/",
@@ -29,63 +47,211 @@ func NewServer(config *Config, wg *WireGuardManager, pf *PortForwardServer) *Ser
 		SameSite: http.SameSiteLaxMode,
 	}
 
+	trustedProxies, _ := parseTrustedProxies(config.TrustedProxies) // validated by LoadConfig
+
 	return &Server{
-		config: config,
-		wg:     wg,
-		pf:     pf,
-		store:  store,
+		config:         config,
+		settings:       settings,
+		wg:             wg,
+		pf:             pf,
+		users:          NewUserStore(config),
+		oidc:           NewOIDCProvider(config),
+		totp:           NewTOTPStore(config),
+		limiter:        NewLoginLimiter(config),
+		tokens:         NewAPITokenStore(config),
+		events:         events,
+		webhooks:       webhooks,
+		auditLog:       auditLog,
+		reloader:       reloader,
+		store:          store,
+		trustedProxies: trustedProxies,
+		started:        time.Now(),
 	}
 }
 
-func (s *Server) isAuthenticated(r *http.Request) bool {
+type contextKey string
+
+const userContextKey contextKey = "user"
+
+// sessionUser returns the logged-in user: the owner of the API token on API
+// requests with an Authorization header, the user authenticated by a trusted
+// reverse proxy, or the one stored in the session. Local accounts are looked
+// up on every request so role changes and deletions take effect immediately;
+// OIDC users carry the role mapped at login in the session.
+func (s *Server) sessionUser(r *http.Request) *User {
+	if secret, ok := bearerToken(r); ok {
+		if !s.isAPIRequest(r) {
+			return nil
+		}
+		return s.tokenUser(secret)
+	}
+	if user := s.proxyUser(r); user != nil {
+		return user
+	}
+
 	session, _ := s.store.Get(r, "session")
-	auth, ok := session.Values["authenticated"].(bool)
-	return ok && auth
+	username, ok := session.Values["username"].(string)
+	if !ok || username == "" {
+		return nil
+	}
+	if session.Values["auth"] == "oidc" {
+		role, _ := session.Values["role"].(string)
+		if !s.oidc.Enabled() || !Role(role).Valid() || !strings.HasPrefix(username, oidcUserPrefix) {
+			return nil
+		}
+		return &User{Username: username, Role: Role(role), External: true}
+	}
+	user, err := s.users.Get(username)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+// currentUser returns the user attached to the request by requireLogin.
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
 }
 
-func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+func (s *Server) requireLogin(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !s.isAuthenticated(r) {
-			http.Redirect(w, r, s.config.BasePath+"/login", http.StatusSeeOther)
+		user := s.sessionUser(r)
+		if user == nil {
+			if s.isAPIRequest(r) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="wg-easy"`)
+				writeJSONError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			s.redirect(w, r, "/login")
 			return
 		}
-		next(w, r)
+		if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+			info.user = user.Username
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
 	}
 }
 
+// require only lets logged-in users whose role grants perm through.
+func (s *Server) require(perm Permission, next http.HandlerFunc) http.HandlerFunc {
+	return s.requireLogin(func(w http.ResponseWriter, r *http.Request) {
+		user := currentUser(r)
+		if !user.Can(perm) {
+			requestLogger(r).Warn("Access denied", "user", user.Username, "role", user.Role, "permission", perm,
+				"method", r.Method, "path", r.URL.Path)
+			if s.isAPIRequest(r) {
+				writeJSONError(w, http.StatusForbidden, fmt.Sprintf("missing permission %s", perm))
+				return
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "GET" {
-		s.renderLogin(w, "")
+		if s.proxyUser(r) != nil {
+			s.redirect(w, r, "/")
+			return
+		}
+		s.renderLogin(w, r, "")
 		return
 	}
 
 	if r.Method == "POST" {
+		username := r.FormValue("username")
+		if username == "" {
+			username = s.config.AdminUsername
+		}
 		password := r.FormValue("password")
+		ip := s.clientIP(r)
+
+		if wait := s.limiter.Check(ip, username); wait > 0 {
+			requestLogger(r).Warn("Login refused, locked out", "user", username, "remote_ip", ip, "method", "password",
+				"lockout", wait.Round(time.Second).String())
+			metrics.logins.Inc("password", "locked")
+			s.auditLogin(r, username, "password", "locked", "")
+			s.renderLogin(w, r, fmt.Sprintf("Too many failed login attempts, try again in %s", wait.Round(time.Second)))
+			return
+		}
 
-		if password == s.config.AdminPassword {
+		if user, ok := s.users.Authenticate(username, password); ok {
+			if s.totp.Enabled(user.Username) {
+				s.beginTwoFactorLogin(w, r, user)
+				return
+			}
+			s.limiter.Succeed(ip, username)
 			session, _ := s.store.Get(r, "session")
-			session.Values["authenticated"] = true
+			session.Values["username"] = user.Username
+			delete(session.Values, csrfSessionKey) // issue a fresh token for the new login
 			session.Save(r, w)
-			http.Redirect(w, r, s.config.BasePath+"/", http.StatusSeeOther)
+			requestLogger(r).Info("User logged in", "user", user.Username, "role", user.Role, "remote_ip", ip, "method", "password")
+			metrics.logins.Inc("password", "success")
+			s.auditLogin(r, user.Username, "password", "success", "")
+			s.redirect(w, r, "/")
 			return
 		}
 
-		s.renderLogin(w, "Invalid password")
+		failures, lockout := s.limiter.Fail(ip, username)
+		requestLogger(r).Warn("Login failed", "user", username, "remote_ip", ip, "method", "password",
+			"failures", failures, "lockout", lockout.String())
+		metrics.logins.Inc("password", "failure")
+		s.auditLogin(r, username, "password", "failure", fmt.Sprintf("%d failures", failures))
+		s.renderLogin(w, r, "Invalid username or password")
 		return
 	}
 }
 
 func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	s.audit(r, "logout", "", nil, nil)
 	session, _ := s.store.Get(r, "session")
-	session.Values["authenticated"] = false
+	delete(session.Values, "username")
+	delete(session.Values, "auth")
+	delete(session.Values, "role")
 	session.Options.MaxAge = -1
 	session.Save(r, w)
-	http.Redirect(w, r, s.config.BasePath+"/login", http.StatusSeeOther)
+	s.redirect(w, r, "/login")
 }
 
-func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+// visibleClients returns the clients the user may see: all of them, or only
+// their own for self-service users.
+func (s *Server) visibleClients(user *User) []*WireGuardClient {
 	clients := s.wg.GetClients()
-	s.renderIndex(w, clients)
+	if !user.OwnClientsOnly() {
+		return clients
+	}
+
+	owned := make([]*WireGuardClient, 0)
+	for _, client := range clients {
+		if client.Owner == user.Username {
+			owned = append(owned, client)
+		}
+	}
+	return owned
+}
+
+// requestClient loads the client named by the {id} route variable. Clients a
+// self-service user does not own are reported as not found.
+func (s *Server) requestClient(r *http.Request) (*WireGuardClient, error) {
+	client, err := s.wg.GetClient(mux.Vars(r)["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	user := currentUser(r)
+	if user.OwnClientsOnly() && client.Owner != user.Username {
+		requestLogger(r).Warn("Access denied, not the client's owner", "user", user.Username, "client_id", client.ID)
+		return nil, fmt.Errorf("client not found")
+	}
+	return client, nil
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	s.renderIndex(w, r, user, s.visibleClients(user))
 }
 
 func (s *Server) handleCreateClient(w http.ResponseWriter, r *http.Request) {
@@ -100,14 +266,46 @@ func (s *Server) handleCreateClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client, err := s.wg.CreateClient(name)
+	user := currentUser(r)
+	client, err := s.createClientFor(user, name, r.FormValue("owner"), nil)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), clientErrorStatus(err))
 		return
 	}
+	requestLogger(r).Info("Client created", "user", user.Username, "client_id", client.ID,
+		"client_name", client.Name, "client_ip", clientIPv4(client))
+	s.audit(r, "client.create", client.ID, nil, auditClient(client))
+
+	s.redirect(w, r, "/")
+}
+
+var errUnknownOwner = errors.New("unknown owner")
+
+// createClientFor creates a client on behalf of user. Self-service users own
+// what they create, within their quota; others may assign an owner.
+func (s *Server) createClientFor(user *User, name, owner string, allowedIPs []string) (*WireGuardClient, error) {
+	quota := 0
+	if user.OwnClientsOnly() {
+		owner, quota = user.Username, s.settings.Current().UserClientQuota
+	} else if owner != "" && !isExternalUsername(owner) {
+		if _, err := s.users.Get(owner); err != nil {
+			return nil, errUnknownOwner
+		}
+	}
+	return s.wg.CreateClient(name, owner, allowedIPs, quota)
+}
 
-	http.Redirect(w, r, s.config.BasePath+"/", http.StatusSeeOther)
-	_ = client
+// clientErrorStatus maps createClientFor and UpdateClient errors to HTTP
+// status codes.
+func clientErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errUnknownOwner), errors.Is(err, errClientNameRequired), errors.Is(err, errInvalidAllowedIP):
+		return http.StatusBadRequest
+	case errors.Is(err, errClientQuota):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func (s *Server) handleDeleteClient(w http.ResponseWriter, r *http.Request) {
@@ -116,41 +314,109 @@ func (s *Server) handleDeleteClient(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	vars := mux.Vars(r)
-	id := vars["id"]
+	client, err := s.requestClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 
-	if err := s.wg.DeleteClient(id); err != nil {
+	if err := s.wg.DeleteClient(client.ID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	requestLogger(r).Info("Client deleted", "user", currentUser(r).Username, "client_id", client.ID, "client_ip", clientIPv4(client))
+	s.audit(r, "client.delete", client.ID, auditClient(client), nil)
 
-	http.Redirect(w, r, s.config.BasePath+"/", http.StatusSeeOther)
+	s.redirect(w, r, "/")
 }
 
-func (s *Server) handleDownloadConfig(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+func (s *Server) handleEnableClient(w http.ResponseWriter, r *http.Request) {
+	s.setClientEnabled(w, r, true)
+}
 
-	client, err := s.wg.GetClient(id)
+func (s *Server) handleDisableClient(w http.ResponseWriter, r *http.Request) {
+	s.setClientEnabled(w, r, false)
+}
+
+func (s *Server) setClientEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	client, err := s.requestClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	updated, err := s.wg.SetClientEnabled(client.ID, enabled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("Client enabled state changed", "user", currentUser(r).Username, "client_id", client.ID, "enabled", enabled)
+	s.audit(r, clientToggleAction(enabled), client.ID, auditClient(client), auditClient(updated))
+
+	s.redirect(w, r, "/")
+}
+
+func (s *Server) handleDownloadConfig(w http.ResponseWriter, r *http.Request) {
+	client, err := s.requestClient(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	config := s.wg.GenerateClientConfig(client)
+	requestLogger(r).Info("Client config downloaded", "user", currentUser(r).Username, "client_id", client.ID)
+	s.audit(r, "client.download_config", client.ID, nil, nil)
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.conf", client.Name))
 	w.Write([]byte(config))
 }
 
+func (s *Server) handleDownloadQRCode(w http.ResponseWriter, r *http.Request) {
+	client, err := s.requestClient(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	png, err := qrcode.Encode(s.wg.GenerateClientConfig(client), qrcode.Medium, 512)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	requestLogger(r).Info("Client QR code downloaded", "user", currentUser(r).Username, "client_id", client.ID)
+	s.audit(r, "client.download_qrcode", client.ID, nil, nil)
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
 func (s *Server) handleAPIClients(w http.ResponseWriter, r *http.Request) {
-	clients := s.wg.GetClients()
+	user := currentUser(r)
+	clients := s.visibleClients(user)
+
+	result := make([]*WireGuardClient, 0, len(clients))
+	for _, client := range clients {
+		result = append(result, clientView(user, client))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(clients)
+	json.NewEncoder(w).Encode(result)
 }
 
-func (s *Server) renderLogin(w http.ResponseWriter, errorMsg string) {
+// clientView returns the client as shown to user: private keys are only
+// included for users who may download configs.
+func clientView(user *User, client *WireGuardClient) *WireGuardClient {
+	if user.Can(PermDownloadConfigs) {
+		return client
+	}
+	redacted := *client
+	redacted.PrivateKey = ""
+	return &redacted
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, errorMsg string) {
 	tmpl := `<!DOCTYPE html>
 <html>
 <head>
@@ -162,26 +428,34 @@ func (s *Server) renderLogin(w http.ResponseWriter, errorMsg string) {
         button:hover { background: #0056b3; }
         .error { color: red; margin: 10px 0; }
         h1 { text-align: center; }
+        .sso { display: block; text-align: center; padding: 10px; margin-top: 20px; background: #6c757d; color: white; text-decoration: none; }
+        .sso:hover { background: #5a6268; }
     </style>
 </head>
 <body>
     <h1>🔒 WireGuard Easy</h1>
     {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
     <form method="POST" action="{{.BasePath}}/login">
-        <input type="password" name="password" placeholder="Admin Password" required autofocus>
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="text" name="username" placeholder="Username" value="{{.AdminUsername}}" required autocomplete="username">
+        <input type="password" name="password" placeholder="Password" required autofocus autocomplete="current-password">
         <button type="submit">Login</button>
     </form>
+    {{if .OIDC}}<a href="{{.BasePath}}/login/oidc" class="sso">Sign in with SSO</a>{{end}}
 </body>
 </html>`
 
 	t := template.Must(template.New("login").Parse(tmpl))
 	t.Execute(w, map[string]interface{}{
-		"Error":    errorMsg,
-		"BasePath": s.config.BasePath,
+		"Error":         errorMsg,
+		"BasePath":      s.basePath(r),
+		"CSRFToken":     s.csrfToken(w, r),
+		"AdminUsername": s.config.AdminUsername,
+		"OIDC":          s.oidc.Enabled(),
 	})
 }
 
-func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient) {
+func (s *Server) renderIndex(w http.ResponseWriter, r *http.Request, user *User, clients []*WireGuardClient) {
 	tmpl := `<!DOCTYPE html>
 <html>
 <head>
@@ -190,8 +464,12 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
         body { font-family: Arial, sans-serif; max-width: 1200px; margin: 0 auto; padding: 20px; }
         h1 { color: #333; }
         .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
-        .logout { padding: 8px 16px; background: #dc3545; color: white; text-decoration: none; border-radius: 4px; }
+        .logout { padding: 8px 16px; background: #dc3545; color: white; text-decoration: none; border: none; border-radius: 4px; cursor: pointer; font-size: 16px; }
         .logout:hover { background: #c82333; }
+        .nav { display: flex; gap: 10px; align-items: center; }
+        .nav-link { padding: 8px 16px; background: #6c757d; color: white; text-decoration: none; border-radius: 4px; }
+        .nav-link:hover { background: #5a6268; }
+        .user { color: #666; }
         .pf-status { background: #fff3cd; padding: 10px 15px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #ffc107; }
         .pf-status.enabled { background: #d4edda; border-left-color: #28a745; }
         .add-form { background: #f8f9fa; padding: 20px; border-radius: 8px; margin-bottom: 20px; }
@@ -211,6 +489,10 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
         .btn-portforward.disabled { background: #6c757d; opacity: 0.6; }
         .btn-delete { background: #dc3545; color: white; }
         .btn-delete:hover { background: #c82333; }
+        .btn-toggle { background: #ffc107; color: #333; }
+        .btn-toggle:hover { background: #e0a800; }
+        .status-enabled { color: #28a745; font-weight: bold; }
+        .status-disabled { color: #6c757d; font-weight: bold; }
         .empty { text-align: center; padding: 40px; color: #666; }
         .code { font-family: monospace; font-size: 12px; color: #666; }
     </style>
@@ -218,7 +500,19 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
 <body>
     <div class="header">
         <h1>🔐 WireGuard Easy</h1>
-        <a href="{{.BasePath}}/logout" class="logout">Logout</a>
+        <div class="nav">
+            <span class="user">👤 {{.User.Username}} ({{.User.Role}})</span>
+            {{if .User.Can "users:manage"}}<a href="{{.BasePath}}/users" class="nav-link">👥 Users</a>{{end}}
+            {{if .User.Can "webhooks:manage"}}<a href="{{.BasePath}}/webhooks" class="nav-link">🪝 Webhooks</a>{{end}}
+            {{if .User.Can "audit:view"}}<a href="{{.BasePath}}/audit" class="nav-link">📜 Audit Log</a>{{end}}
+            {{if .User.Can "config:manage"}}<a href="{{.BasePath}}/config" class="nav-link">⚙️ Configuration</a>{{end}}
+            {{if not .User.External}}<a href="{{.BasePath}}/account/2fa" class="nav-link">🔑 2FA</a>
+            <a href="{{.BasePath}}/account/tokens" class="nav-link">🎫 API Tokens</a>{{end}}
+            <form method="POST" action="{{.BasePath}}/logout" style="display: inline;">
+                <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+                <button type="submit" class="logout">Logout</button>
+            </form>
+        </div>
     </div>
 
     {{if .PortForwardEnabled}}
@@ -231,13 +525,22 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
     </div>
     {{end}}
 
+    {{if .User.Can "clients:create"}}
     <div class="add-form">
         <h2>Add New Client</h2>
+        {{if .User.OwnClientsOnly}}
+        <p>You are using {{len .Clients}} of {{.Quota}} clients.</p>
+        {{end}}
+        {{if or (not .User.OwnClientsOnly) (lt (len .Clients) .Quota)}}
         <form method="POST" action="{{.BasePath}}/clients/create">
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
             <input type="text" name="name" placeholder="Client Name" required>
+            {{if not .User.OwnClientsOnly}}<input type="text" name="owner" placeholder="Owner (optional)">{{end}}
             <button type="submit">➕ Add Client</button>
         </form>
+        {{end}}
     </div>
+    {{end}}
 
     {{if .Clients}}
     <table>
@@ -247,6 +550,8 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
                 <th>IPv4 Address</th>
                 <th>IPv6 Address</th>
                 <th>Public Key</th>
+                {{if not .User.OwnClientsOnly}}<th>Owner</th>{{end}}
+                <th>Status</th>
                 <th>Actions</th>
             </tr>
         </thead>
@@ -257,12 +562,33 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
                 <td class="code">{{.AddressV4}}</td>
                 <td class="code">{{.AddressV6}}</td>
                 <td class="code">{{slice .PublicKey 0 20}}...</td>
+                {{if not $.User.OwnClientsOnly}}<td>{{.Owner}}</td>{{end}}
+                <td>{{if .Enabled}}<span class="status-enabled">Enabled</span>{{else}}<span class="status-disabled">Disabled</span>{{end}}</td>
                 <td class="actions">
+                    {{if $.User.Can "clients:download"}}
                     <a href="{{$.BasePath}}/clients/{{.ID}}/config" class="btn btn-download">📥 Download</a>
+                    <a href="{{$.BasePath}}/clients/{{.ID}}/qrcode" class="btn btn-download" target="_blank">📱 QR</a>
+                    {{end}}
                     <a href="{{$.BasePath}}/clients/{{.ID}}/portforwards" class="btn btn-portforward{{if not $.PortForwardEnabled}} disabled{{end}}">🔌 Ports</a>
+                    {{if $.User.Can "clients:toggle"}}
+                    {{if .Enabled}}
+                    <form method="POST" action="{{$.BasePath}}/clients/{{.ID}}/disable" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-toggle">⏸️ Disable</button>
+                    </form>
+                    {{else}}
+                    <form method="POST" action="{{$.BasePath}}/clients/{{.ID}}/enable" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-toggle">▶️ Enable</button>
+                    </form>
+                    {{end}}
+                    {{end}}
+                    {{if $.User.Can "clients:delete"}}
                     <form method="POST" action="{{$.BasePath}}/clients/{{.ID}}/delete" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                         <button type="submit" class="btn btn-delete" onclick="return confirm('Delete {{.Name}}?')">🗑️ Delete</button>
                     </form>
+                    {{end}}
                 </td>
             </tr>
             {{end}}
@@ -273,6 +599,20 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
         <p>No clients yet. Add your first client above!</p>
     </div>
     {{end}}
+    <script>
+        // Live updates: reload when clients change
+        (function () {
+            if (!window.EventSource) return;
+            var source = new EventSource({{.BasePath}} + "/api/events");
+            var reload = null;
+            ["client.created", "client.deleted", "client.enabled", "client.disabled", "client.updated"].forEach(function (type) {
+                source.addEventListener(type, function () {
+                    clearTimeout(reload);
+                    reload = setTimeout(function () { location.reload(); }, 500);
+                });
+            });
+        })();
+    </script>
 </body>
 </html>`
 
@@ -286,8 +626,11 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
 	}).Parse(tmpl))
 
 	t.Execute(w, map[string]interface{}{
+		"User":               user,
 		"Clients":            clients,
-		"BasePath":           s.config.BasePath,
+		"Quota":              s.settings.Current().UserClientQuota,
+		"BasePath":           s.basePath(r),
+		"CSRFToken":          s.csrfToken(w, r),
 		"PortForwardEnabled": s.pf.IsEnabled(),
 	})
 }
@@ -295,10 +638,7 @@ func (s *Server) renderIndex(w http.ResponseWriter, clients []*WireGuardClient)
 // Port forwarding handlers
 
 func (s *Server) handlePortForwards(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	clientID := vars["id"]
-
-	client, err := s.wg.GetClient(clientID)
+	client, err := s.requestClient(r)
 	if err != nil {
 		http.Error(w, "Client not found", http.StatusNotFound)
 		return
@@ -310,8 +650,9 @@ func (s *Server) handlePortForwards(w http.ResponseWriter, r *http.Request) {
 		clientIP = ip.String()
 	}
 	mappings := s.pf.GetClientMappings(clientIP)
+	events := s.pf.GetEvents(clientIP, 50)
 
-	s.renderPortForwards(w, client, mappings, s.config.WgEndpoint, "")
+	s.renderPortForwards(w, r, currentUser(r), client, mappings, events, s.settings.Current().WgEndpoint, "")
 }
 
 func (s *Server) handleAddPortForward(w http.ResponseWriter, r *http.Request) {
@@ -326,11 +667,10 @@ func (s *Server) handleDeletePortForward(w http.ResponseWriter, r *http.Request)
 	}
 
 	vars := mux.Vars(r)
-	clientID := vars["id"]
 	externalPort := vars["port"]
 	protocol := vars["protocol"]
 
-	client, err := s.wg.GetClient(clientID)
+	client, err := s.requestClient(r)
 	if err != nil {
 		http.Error(w, "Client not found", http.StatusNotFound)
 		return
@@ -348,19 +688,20 @@ func (s *Server) handleDeletePortForward(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := s.pf.removeMapping(clientIP, port, protocol); err != nil {
+	mapping, err := s.pf.removeMapping(clientIP, port, protocol, "removed by "+currentUser(r).Username)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	requestLogger(r).Info("Port forward removed", "user", currentUser(r).Username, "client_id", client.ID,
+		"client_ip", clientIP, "ext_port", port, "protocol", protocol)
+	s.audit(r, "portforward.delete", mappingTarget(mapping), mapping, nil)
 
-	http.Redirect(w, r, fmt.Sprintf("%s/clients/%s/portforwards", s.config.BasePath, clientID), http.StatusSeeOther)
+	s.redirect(w, r, "/clients/"+client.ID+"/portforwards")
 }
 
 func (s *Server) handleAPIPortForwards(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	clientID := vars["id"]
-
-	client, err := s.wg.GetClient(clientID)
+	client, err := s.requestClient(r)
 	if err != nil {
 		http.Error(w, "Client not found", http.StatusNotFound)
 		return
@@ -376,13 +717,57 @@ func (s *Server) handleAPIPortForwards(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(mappings)
 }
 
+// ownedClientIPs returns the VPN IPv4 addresses of a self-service user's
+// clients, or nil if the user may see every client.
+func (s *Server) ownedClientIPs(user *User) map[string]bool {
+	if !user.OwnClientsOnly() {
+		return nil
+	}
+
+	ips := make(map[string]bool)
+	for _, client := range s.visibleClients(user) {
+		if ip, _, err := net.ParseCIDR(client.AddressV4); err == nil {
+			ips[ip.String()] = true
+		}
+	}
+	return ips
+}
+
 func (s *Server) handleAPIAllPortForwards(w http.ResponseWriter, r *http.Request) {
 	mappings := s.pf.GetAllMappings()
+
+	if owned := s.ownedClientIPs(currentUser(r)); owned != nil {
+		filtered := make([]*PortMapping, 0)
+		for _, mapping := range mappings {
+			if owned[mapping.ClientIP] {
+				filtered = append(filtered, mapping)
+			}
+		}
+		mappings = filtered
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(mappings)
 }
 
-func (s *Server) renderPortForwards(w http.ResponseWriter, client *WireGuardClient, mappings []*PortMapping, externalIP, errorMsg string) {
+func (s *Server) handleAPIPortForwardEvents(w http.ResponseWriter, r *http.Request) {
+	events := s.pf.GetEvents(r.URL.Query().Get("client_ip"), mappingEventHistory)
+
+	if owned := s.ownedClientIPs(currentUser(r)); owned != nil {
+		filtered := make([]MappingEvent, 0)
+		for _, event := range events {
+			if owned[event.Mapping.ClientIP] {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+func (s *Server) renderPortForwards(w http.ResponseWriter, r *http.Request, user *User, client *WireGuardClient, mappings []*PortMapping, events []MappingEvent, externalIP, errorMsg string) {
 	tmpl := `<!DOCTYPE html>
 <html>
 <head>
@@ -413,6 +798,12 @@ func (s *Server) renderPortForwards(w http.ResponseWriter, client *WireGuardClie
         .code { font-family: monospace; font-size: 12px; color: #666; }
         .form-row { margin-bottom: 10px; }
         .form-row label { display: inline-block; width: 120px; }
+        h2 { margin-top: 30px; }
+        .event { padding: 2px 8px; border-radius: 4px; font-size: 12px; color: white; background: #6c757d; }
+        .event-created { background: #28a745; }
+        .event-renewed { background: #17a2b8; }
+        .event-expired { background: #ffc107; color: #333; }
+        .event-removed { background: #dc3545; }
     </style>
 </head>
 <body>
@@ -456,6 +847,8 @@ func (s *Server) renderPortForwards(w http.ResponseWriter, client *WireGuardClie
                 <th>Protocol</th>
                 <th>Description</th>
                 <th>Created</th>
+                <th>Renewals</th>
+                <th>Expires</th>
                 <th>Actions</th>
             </tr>
         </thead>
@@ -467,10 +860,15 @@ func (s *Server) renderPortForwards(w http.ResponseWriter, client *WireGuardClie
                 <td>{{.Protocol | upper}}</td>
                 <td>{{.Description}}</td>
                 <td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td>
+                <td>{{.RenewalCount}}{{if .RenewalCount}} <span class="code">(last {{.LastRenewed.Format "15:04:05"}})</span>{{end}}</td>
+                <td>{{.ExpiresAt.Format "15:04:05"}}</td>
                 <td class="actions">
+                    {{if $.User.Can "portforwards:manage"}}
                     <form method="POST" action="{{$.BasePath}}/clients/{{$.Client.ID}}/portforwards/{{.ExternalPort}}/{{.Protocol}}/delete" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
                         <button type="submit" class="btn btn-delete" onclick="return confirm('Delete port forward {{.ExternalPort}}?')">🗑️ Delete</button>
                     </form>
+                    {{end}}
                 </td>
             </tr>
             {{end}}
@@ -481,6 +879,36 @@ func (s *Server) renderPortForwards(w http.ResponseWriter, client *WireGuardClie
         <p>No port forwards configured. Add one above!</p>
     </div>
     {{end}}
+
+    {{if .Events}}
+    <h2>Recent Events</h2>
+    <table>
+        <thead>
+            <tr>
+                <th>Time</th>
+                <th>Event</th>
+                <th>External Port</th>
+                <th>Internal Port</th>
+                <th>Protocol</th>
+                <th>Renewals</th>
+                <th>Reason</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Events}}
+            <tr>
+                <td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+                <td><span class="event event-{{.Type}}">{{.Type}}</span></td>
+                <td>{{.Mapping.ExternalPort}}</td>
+                <td class="code">{{.Mapping.ClientIP}}:{{.Mapping.InternalPort}}</td>
+                <td>{{.Mapping.Protocol | upper}}</td>
+                <td>{{.Mapping.RenewalCount}}</td>
+                <td>{{.Reason}}</td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{end}}
     {{else}}
     <div class="disabled">
         <strong>⚠️ NAT-PMP server is not running</strong><br>
@@ -488,6 +916,22 @@ func (s *Server) renderPortForwards(w http.ResponseWriter, client *WireGuardClie
         Set <code>"port_forward_enabled": true</code> in config.json and restart the server.
     </div>
     {{end}}
+    <script>
+        // Live updates: reload when this client's port forwards change
+        (function () {
+            if (!window.EventSource) return;
+            var source = new EventSource({{.BasePath}} + "/api/events");
+            var clientIP = {{trimCIDR .Client.AddressV4}};
+            var reload = null;
+            ["mapping.created", "mapping.renewed", "mapping.expired", "mapping.removed"].forEach(function (type) {
+                source.addEventListener(type, function (e) {
+                    if (JSON.parse(e.data).client_ip !== clientIP) return;
+                    clearTimeout(reload);
+                    reload = setTimeout(function () { location.reload(); }, 500);
+                });
+            });
+        })();
+    </script>
 </body>
 </html>`
 
@@ -504,11 +948,14 @@ func (s *Server) renderPortForwards(w http.ResponseWriter, client *WireGuardClie
 	}).Parse(tmpl))
 
 	t.Execute(w, map[string]interface{}{
+		"User":       user,
 		"Client":     client,
 		"Mappings":   mappings,
+		"Events":     events,
 		"ExternalIP": externalIP,
 		"Error":      errorMsg,
 		"Enabled":    s.pf.IsEnabled(),
-		"BasePath":   s.config.BasePath,
+		"BasePath":   s.basePath(r),
+		"CSRFToken":  s.csrfToken(w, r),
 	})
 }
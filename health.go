@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health and readiness probes for orchestrators and the Docker HEALTHCHECK.
+// /healthz only says the process is serving; /readyz checks the subsystems
+// the server needs to do its job.
+
+// readyCheckTimeout bounds checks that run external commands.
+const readyCheckTimeout = 5 * time.Second
+
+// Status of a readiness check
+const (
+	CheckOK       = "ok"
+	CheckFailed   = "failed"
+	CheckDisabled = "disabled"
+)
+
+type readyCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type readyStatus struct {
+	Status string                `json:"status"` // "ready" or "not ready"
+	Checks map[string]readyCheck `json:"checks"`
+}
+
+func checkResult(detail string, err error) readyCheck {
+	if err != nil {
+		return readyCheck{Status: CheckFailed, Error: err.Error()}
+	}
+	return readyCheck{Status: CheckOK, Detail: detail}
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	markProbe(r)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// runHealthcheck implements the "healthcheck" subcommand used by the Docker
+// HEALTHCHECK. It loads the config like the server does and fails unless
+// /readyz on its listen_addr and base_path answers 200.
+func runHealthcheck(args []string) error {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: wg-easy-go healthcheck [config]")
+		fmt.Fprintln(fs.Output(), "Exits non-zero unless the running server reports ready.")
+	}
+	fs.Parse(args)
+
+	config, err := LoadConfig(findConfigFile(fs.Args()))
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	url, err := readyzURL(config)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 2 * readyCheckTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// readyzURL returns the local /readyz URL of the server. A wildcard
+// listen_addr is probed on the loopback address.
+func readyzURL(config *Config) (string, error) {
+	host, port, err := net.SplitHostPort(config.ListenAddr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen_addr: %v", err)
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + strings.TrimSuffix(config.BasePath, "/") + "/readyz", nil
+}
+
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	markProbe(r)
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyCheckTimeout)
+	defer cancel()
+
+	checks := map[string]readyCheck{
+		"wireguard": checkResult(s.config.WgInterface+" is up", s.wg.CheckInterface()),
+		"firewall":  checkResult("iptables works", s.pf.CheckFirewall(ctx)),
+		"state_dir": checkResult(s.config.StateDir+" is writable", checkStateDir(s.config)),
+	}
+	if s.config.PortForwardEnabled {
+		addr, err := s.pf.NATPMPAddr()
+		checks["natpmp"] = checkResult("listening on "+addr, err)
+	} else {
+		checks["natpmp"] = readyCheck{Status: CheckDisabled}
+	}
+
+	status := readyStatus{Status: "ready", Checks: checks}
+	code := http.StatusOK
+	for _, check := range checks {
+		if check.Status == CheckFailed {
+			status.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, code, status)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Structured logging via log/slog. Log lines carry fields rather than
+// formatted text so they can be queried, e.g. in Loki:
+//
+//	{app="wg-easy"} | json | client_id="client-2"
+//
+// Common keys: request_id, user, remote_ip, client_id, client_name,
+// client_ip, ext_port, int_port, protocol, result_code, error.
+
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel is the minimum level logged. It is a LevelVar so the level can be
+// changed without replacing the handler.
+var logLevel = new(slog.LevelVar)
+
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return 0, fmt.Errorf("invalid log_level %q (debug, info, warn or error)", value)
+	}
+	return level, nil
+}
+
+// setupLogging installs the slog handler selected by log_format as the
+// default logger. Output of the standard log package goes through it too.
+func setupLogging(config *Config) {
+	level, _ := parseLogLevel(config.LogLevel) // validated by LoadConfig
+	logLevel.Set(level)
+
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if config.LogFormat == LogFormatJSON {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+const requestIDHeader = "X-Request-ID"
+
+const requestInfoContextKey contextKey = "request"
+
+// requestInfo is attached to each request by accessLog. requireLogin fills
+// in the user so the access log can name them.
+type requestInfo struct {
+	id    string
+	user  string
+	probe bool // health check, logged at debug level unless it fails
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts IDs set by a proxy if they are short and printable.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(r rune) bool { return r <= ' ' || r > '~' })
+}
+
+// markProbe keeps successful health checks out of the access log at the
+// default level; orchestrators poll them every few seconds.
+func markProbe(r *http.Request) {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		info.probe = true
+	}
+}
+
+// requestLogger returns the default logger with the request's ID attached.
+func requestLogger(r *http.Request) *slog.Logger {
+	if info, ok := r.Context().Value(requestInfoContextKey).(*requestInfo); ok {
+		return slog.Default().With("request_id", info.id)
+	}
+	return slog.Default()
+}
+
+// statusRecorder captures the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush keeps event streams working through the recorder.
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// accessLog assigns each request an ID, returned in X-Request-ID, and logs
+// it once it has been served. Trusted proxies may pass their own ID.
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) || !s.fromTrustedProxy(r) {
+			id = newRequestID()
+		}
+		info := &requestInfo{id: id}
+		w.Header().Set(requestIDHeader, id)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoContextKey, info)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if rec.status >= 500 {
+			level = slog.LevelError
+		} else if info.probe {
+			level = slog.LevelDebug
+		}
+		slog.Default().LogAttrs(r.Context(), level, "request",
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("user", info.user),
+			slog.String("remote_ip", s.clientIP(r)),
+		)
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	loginBackoffBase    = time.Second
+	loginFailureWindow  = 24 * time.Hour // failures older than this are forgotten
+	loginLimiterMaxKeys = 10000          // beyond this the oldest failures are forgotten first
+)
+
+// LoginLockout is the failed-login state of one client IP or account.
+type LoginLockout struct {
+	Key         string    `json:"key"` // "ip:<address>" or "user:<username>"
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// Locked reports whether logins are currently refused.
+func (l *LoginLockout) Locked(now time.Time) bool {
+	return now.Before(l.LockedUntil)
+}
+
+// LoginLimiter tracks failed logins per client IP and per account. The first
+// login_max_attempts failures are free; after that every failure locks the
+// IP or account for an exponentially growing time (1s, 2s, 4s, ...) capped at
+// login_lockout_max seconds. A successful login clears both.
+type LoginLimiter struct {
+	config  *Config
+	mu      sync.Mutex
+	entries map[string]*LoginLockout
+}
+
+func NewLoginLimiter(config *Config) *LoginLimiter {
+	return &LoginLimiter{
+		config:  config,
+		entries: make(map[string]*LoginLockout),
+	}
+}
+
+func loginIPKey(ip string) string         { return "ip:" + ip }
+func loginUserKey(username string) string { return "user:" + username }
+
+// Check returns how long the IP or account is still locked, or 0 if the
+// login may proceed.
+func (ll *LoginLimiter) Check(ip, username string) time.Duration {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []string{loginIPKey(ip), loginUserKey(username)} {
+		if entry, ok := ll.entries[key]; ok && entry.Locked(now) {
+			if d := entry.LockedUntil.Sub(now); d > wait {
+				wait = d
+			}
+		}
+	}
+	return wait
+}
+
+// Fail records a failed login and returns the resulting failure count and
+// lockout of the account.
+func (ll *LoginLimiter) Fail(ip, username string) (int, time.Duration) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	now := time.Now()
+	var failures int
+	var lockout time.Duration
+	for _, key := range []string{loginIPKey(ip), loginUserKey(username)} {
+		entry, ok := ll.entries[key]
+		if !ok {
+			entry = ll.add(key, now)
+		} else if now.Sub(entry.LastFailure) > loginFailureWindow {
+			*entry = LoginLockout{Key: key}
+		}
+		entry.Failures++
+		entry.LastFailure = now
+
+		if over := entry.Failures - ll.config.LoginMaxAttempts; over > 0 {
+			d := ll.backoff(over)
+			entry.LockedUntil = now.Add(d)
+			if d > lockout {
+				lockout = d
+			}
+		}
+		if entry.Failures > failures {
+			failures = entry.Failures
+		}
+	}
+	return failures, lockout
+}
+
+func (ll *LoginLimiter) backoff(over int) time.Duration {
+	max := time.Duration(ll.config.LoginLockoutMax) * time.Second
+	if over > 32 {
+		return max
+	}
+	d := loginBackoffBase << (over - 1)
+	if d > max {
+		return max
+	}
+	return d
+}
+
+// Succeed clears the failures of the IP and account.
+func (ll *LoginLimiter) Succeed(ip, username string) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	delete(ll.entries, loginIPKey(ip))
+	delete(ll.entries, loginUserKey(username))
+}
+
+// List returns the IPs and accounts with recent failures, locked ones first.
+func (ll *LoginLimiter) List() []LoginLockout {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	now := time.Now()
+	ll.prune(now)
+
+	result := make([]LoginLockout, 0, len(ll.entries))
+	for _, entry := range ll.entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if li, lj := result[i].Locked(now), result[j].Locked(now); li != lj {
+			return li
+		}
+		return result[i].LastFailure.After(result[j].LastFailure)
+	})
+	return result
+}
+
+// Clear removes the failures recorded for key.
+func (ll *LoginLimiter) Clear(key string) error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if _, ok := ll.entries[key]; !ok {
+		return fmt.Errorf("no lockout for %q", key)
+	}
+	delete(ll.entries, key)
+	return nil
+}
+
+// add creates the entry for key. When the limiter is full it forgets
+// expired entries and, if that is not enough, the oldest tenth of the
+// entries, unlocked ones first. ll.mu must be held.
+func (ll *LoginLimiter) add(key string, now time.Time) *LoginLockout {
+	if len(ll.entries) >= loginLimiterMaxKeys {
+		ll.prune(now)
+	}
+	if len(ll.entries) >= loginLimiterMaxKeys {
+		ll.evict(now, len(ll.entries)-loginLimiterMaxKeys*9/10)
+	}
+
+	entry := &LoginLockout{Key: key}
+	ll.entries[key] = entry
+	return entry
+}
+
+// evict forgets n entries: unlocked ones before locked ones, oldest failure
+// first. ll.mu must be held.
+func (ll *LoginLimiter) evict(now time.Time, n int) {
+	entries := make([]*LoginLockout, 0, len(ll.entries))
+	for _, entry := range ll.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if li, lj := entries[i].Locked(now), entries[j].Locked(now); li != lj {
+			return lj
+		}
+		return entries[i].LastFailure.Before(entries[j].LastFailure)
+	})
+	for _, entry := range entries[:n] {
+		delete(ll.entries, entry.Key)
+	}
+}
+
+// prune must be called with ll.mu held.
+func (ll *LoginLimiter) prune(now time.Time) {
+	for key, entry := range ll.entries {
+		if !entry.Locked(now) && now.Sub(entry.LastFailure) > loginFailureWindow {
+			delete(ll.entries, key)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLoginLimiterMaxKeys(t *testing.T) {
+	ll := NewLoginLimiter(&Config{LoginMaxAttempts: 1, LoginLockoutMax: 900})
+
+	// Locked before the flood, so it must survive it
+	for i := 0; i < 10; i++ {
+		ll.Fail("192.0.2.1", "admin")
+	}
+	if ll.Check("192.0.2.1", "admin") == 0 {
+		t.Fatal("admin is not locked")
+	}
+
+	// Every failure adds an IP and a username key
+	for i := 0; i < loginLimiterMaxKeys; i++ {
+		ll.Fail(fmt.Sprintf("10.%d.%d.%d", i>>16&255, i>>8&255, i&255), fmt.Sprintf("user%d", i))
+		if len(ll.entries) > loginLimiterMaxKeys {
+			t.Fatalf("%d entries after %d failures, want at most %d", len(ll.entries), i+1, loginLimiterMaxKeys)
+		}
+	}
+
+	if len(ll.entries) < loginLimiterMaxKeys*9/10 {
+		t.Errorf("%d entries, want at least %d", len(ll.entries), loginLimiterMaxKeys*9/10)
+	}
+	if ll.Check("192.0.2.1", "admin") == 0 {
+		t.Error("admin lockout was evicted")
+	}
+	last := loginLimiterMaxKeys - 1
+	if _, ok := ll.entries[loginUserKey(fmt.Sprintf("user%d", last))]; !ok {
+		t.Error("the newest failure was evicted")
+	}
+}
@@ -1,44 +1,83 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
-	// Load configuration
-	configPath := "config.json"
-	if len(os.Args) > 1 {
-		configPath = os.Args[1]
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		if err := runHashPassword(os.Args[2:]); err != nil {
+			log.Fatalf("hash-password: %v", err)
+		}
+		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		if err := runHealthcheck(os.Args[2:]); err != nil {
+			log.Fatalf("healthcheck: %v", err)
+		}
+		return
+	}
+
+	// Load configuration: the file named on the command line or config.json,
+	// overridden by WG_EASY_* variables. Without either file, the
+	// environment alone configures the server.
+	configPath := findConfigFile(os.Args[1:])
 
 	config, err := LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	setupLogging(config)
+
+	// Cancelled on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Changes published by the managers, streamed at /api/events
+	events := NewEventBus()
+	webhooks := NewWebhookDispatcher(config, events)
+	go webhooks.Run(ctx)
+
+	// Who did what, shown on the Audit Log page
+	auditLog := NewAuditLog(config)
+	go auditLog.Run(ctx)
+
+	// Settings edited in the UI, layered over the config
+	settings := NewSettingsStore(config)
 
 	// Initialize WireGuard manager
-	wgManager := NewWireGuardManager(config)
+	wgManager := NewWireGuardManager(config, settings, events)
 
 	// Ensure WireGuard interface exists
 	if err := wgManager.EnsureInterface(); err != nil {
-		log.Printf("Warning: Failed to ensure WireGuard interface: %v", err)
-		log.Printf("Make sure WireGuard is installed and you have root privileges")
+		slog.Warn("Failed to ensure WireGuard interface, make sure WireGuard is installed and you have root privileges",
+			"interface", config.WgInterface, "error", err)
 	}
 
 	// Initialize port forward server
-	pfServer := NewPortForwardServer(config)
-	defer pfServer.Cleanup()
+	pfServer := NewPortForwardServer(ctx, config, settings, events, auditLog)
 
 	// Link managers
 	wgManager.SetPortForwardServer(pfServer)
+	go wgManager.MonitorPeers(ctx)
+
+	// Re-reads the config on SIGHUP and from the Configuration page
+	reloader := NewConfigReloader(configPath, config, settings, pfServer, auditLog)
+	go reloader.Run(ctx)
 
 	// Initialize server
-	server := NewServer(config, wgManager, pfServer)
+	server := NewServer(config, settings, wgManager, pfServer, events, webhooks, auditLog, reloader)
 
 	// Setup router
 	r := mux.NewRouter()
@@ -48,23 +87,94 @@ func main() {
 
 	// Public routes
 	r.HandleFunc(basePath+"/login", server.handleLogin).Methods("GET", "POST")
+	r.HandleFunc(basePath+"/login/2fa", server.handleLogin2FA).Methods("GET", "POST")
+	r.HandleFunc(basePath+"/login/oidc", server.handleOIDCLogin).Methods("GET")
+	r.HandleFunc(basePath+"/login/oidc/callback", server.handleOIDCCallback).Methods("GET")
+	r.HandleFunc(basePath+"/healthz", server.handleHealthz).Methods("GET")
+	r.HandleFunc(basePath+"/readyz", server.handleReadyz).Methods("GET")
 
 	// Protected routes
-	r.HandleFunc(basePath+"/", server.requireAuth(server.handleIndex)).Methods("GET")
-	r.HandleFunc(basePath+"/logout", server.requireAuth(server.handleLogout)).Methods("GET")
-	r.HandleFunc(basePath+"/clients/create", server.requireAuth(server.handleCreateClient)).Methods("POST")
-	r.HandleFunc(basePath+"/clients/{id}/delete", server.requireAuth(server.handleDeleteClient)).Methods("POST")
-	r.HandleFunc(basePath+"/clients/{id}/config", server.requireAuth(server.handleDownloadConfig)).Methods("GET")
+	r.HandleFunc(basePath+"/", server.require(PermViewClients, server.handleIndex)).Methods("GET")
+	r.HandleFunc(basePath+"/logout", server.requireLogin(server.handleLogout)).Methods("POST")
+	r.HandleFunc(basePath+"/account/2fa", server.requireLogin(server.handleAccount2FA)).Methods("GET")
+	r.HandleFunc(basePath+"/account/2fa/enable", server.requireLogin(server.handleEnable2FA)).Methods("POST")
+	r.HandleFunc(basePath+"/account/2fa/disable", server.requireLogin(server.handleDisable2FA)).Methods("POST")
+	r.HandleFunc(basePath+"/account/tokens", server.requireLogin(server.handleTokens)).Methods("GET")
+	r.HandleFunc(basePath+"/account/tokens/create", server.requireLogin(server.handleCreateToken)).Methods("POST")
+	r.HandleFunc(basePath+"/account/tokens/{id}/revoke", server.requireLogin(server.handleRevokeToken)).Methods("POST")
+	r.HandleFunc(basePath+"/clients/create", server.require(PermCreateClients, server.handleCreateClient)).Methods("POST")
+	r.HandleFunc(basePath+"/clients/{id}/delete", server.require(PermDeleteClients, server.handleDeleteClient)).Methods("POST")
+	r.HandleFunc(basePath+"/clients/{id}/enable", server.require(PermToggleClients, server.handleEnableClient)).Methods("POST")
+	r.HandleFunc(basePath+"/clients/{id}/disable", server.require(PermToggleClients, server.handleDisableClient)).Methods("POST")
+	r.HandleFunc(basePath+"/clients/{id}/config", server.require(PermDownloadConfigs, server.handleDownloadConfig)).Methods("GET")
+	r.HandleFunc(basePath+"/clients/{id}/qrcode", server.require(PermDownloadConfigs, server.handleDownloadQRCode)).Methods("GET")
 
 	// Port forwarding routes
-	r.HandleFunc(basePath+"/clients/{id}/portforwards", server.requireAuth(server.handlePortForwards)).Methods("GET")
-	r.HandleFunc(basePath+"/clients/{id}/portforwards/add", server.requireAuth(server.handleAddPortForward)).Methods("POST")
-	r.HandleFunc(basePath+"/clients/{id}/portforwards/{port}/{protocol}/delete", server.requireAuth(server.handleDeletePortForward)).Methods("POST")
+	r.HandleFunc(basePath+"/clients/{id}/portforwards", server.require(PermViewPortForwards, server.handlePortForwards)).Methods("GET")
+	r.HandleFunc(basePath+"/clients/{id}/portforwards/add", server.require(PermManagePortForwards, server.handleAddPortForward)).Methods("POST")
+	r.HandleFunc(basePath+"/clients/{id}/portforwards/{port}/{protocol}/delete", server.require(PermManagePortForwards, server.handleDeletePortForward)).Methods("POST")
+
+	// User management routes
+	r.HandleFunc(basePath+"/users", server.require(PermManageUsers, server.handleUsers)).Methods("GET")
+	r.HandleFunc(basePath+"/users/create", server.require(PermManageUsers, server.handleCreateUser)).Methods("POST")
+	r.HandleFunc(basePath+"/users/{username}/update", server.require(PermManageUsers, server.handleUpdateUser)).Methods("POST")
+	r.HandleFunc(basePath+"/users/{username}/delete", server.require(PermManageUsers, server.handleDeleteUser)).Methods("POST")
+	r.HandleFunc(basePath+"/users/lockouts/clear", server.require(PermManageUsers, server.handleClearLockout)).Methods("POST")
+	r.HandleFunc(basePath+"/users/{username}/2fa/reset", server.require(PermManageUsers, server.handleReset2FA)).Methods("POST")
+	r.HandleFunc(basePath+"/webhooks", server.require(PermManageWebhooks, server.handleWebhooks)).Methods("GET")
+	r.HandleFunc(basePath+"/webhooks/{name}/test", server.require(PermManageWebhooks, server.handleTestWebhook)).Methods("POST")
+	r.HandleFunc(basePath+"/webhooks/deliveries/{id}/retry", server.require(PermManageWebhooks, server.handleRetryWebhookDelivery)).Methods("POST")
+	r.HandleFunc(basePath+"/audit", server.require(PermViewAudit, server.handleAudit)).Methods("GET")
+	r.HandleFunc(basePath+"/config", server.require(PermManageConfig, server.handleConfig)).Methods("GET")
+	r.HandleFunc(basePath+"/config", server.require(PermManageConfig, server.handleUpdateSettings)).Methods("POST")
+	r.HandleFunc(basePath+"/config/reload", server.require(PermManageConfig, server.handleReloadConfig)).Methods("POST")
 
 	// API routes
-	r.HandleFunc(basePath+"/api/clients", server.requireAuth(server.handleAPIClients)).Methods("GET")
-	r.HandleFunc(basePath+"/api/clients/{id}/portforwards", server.requireAuth(server.handleAPIPortForwards)).Methods("GET")
-	r.HandleFunc(basePath+"/api/portforwards", server.requireAuth(server.handleAPIAllPortForwards)).Methods("GET")
+	r.HandleFunc(basePath+"/api/openapi.json", server.handleAPIOpenAPI).Methods("GET")
+	r.HandleFunc(basePath+"/api/csrf", server.requireLogin(server.handleAPICSRFToken)).Methods("GET")
+	r.HandleFunc(basePath+"/api/clients", server.require(PermViewClients, server.handleAPIClients)).Methods("GET")
+	r.HandleFunc(basePath+"/api/clients/{id}/portforwards", server.require(PermViewPortForwards, server.handleAPIPortForwards)).Methods("GET")
+	r.HandleFunc(basePath+"/api/portforwards", server.require(PermViewPortForwards, server.handleAPIAllPortForwards)).Methods("GET")
+	r.HandleFunc(basePath+"/api/events", server.require(PermViewClients, server.handleAPIEvents)).Methods("GET")
+	r.HandleFunc(basePath+"/api/portforwards/events", server.require(PermViewPortForwards, server.handleAPIPortForwardEvents)).Methods("GET")
+	r.HandleFunc(basePath+"/api/users", server.require(PermManageUsers, server.handleAPIUsers)).Methods("GET")
+	r.HandleFunc(basePath+"/api/users", server.require(PermManageUsers, server.handleAPICreateUser)).Methods("POST")
+	r.HandleFunc(basePath+"/api/users/{username}", server.require(PermManageUsers, server.handleAPIUpdateUser)).Methods("PATCH")
+	r.HandleFunc(basePath+"/api/users/{username}", server.require(PermManageUsers, server.handleAPIDeleteUser)).Methods("DELETE")
+	r.HandleFunc(basePath+"/api/tokens", server.requireLogin(server.handleAPITokens)).Methods("GET")
+	r.HandleFunc(basePath+"/api/tokens", server.requireLogin(server.handleAPICreateToken)).Methods("POST")
+	r.HandleFunc(basePath+"/api/tokens/{id}", server.requireLogin(server.handleAPIRevokeToken)).Methods("DELETE")
+	r.HandleFunc(basePath+"/api/webhooks/deliveries", server.require(PermManageWebhooks, server.handleAPIWebhookDeliveries)).Methods("GET")
+	r.HandleFunc(basePath+"/api/webhooks/deliveries/{id}/retry", server.require(PermManageWebhooks, server.handleAPIRetryWebhookDelivery)).Methods("POST")
+	r.HandleFunc(basePath+"/api/audit", server.require(PermViewAudit, server.handleAPIAudit)).Methods("GET")
+	r.HandleFunc(basePath+"/api/config/reload", server.require(PermManageConfig, server.handleAPIReloadConfig)).Methods("POST")
+	r.HandleFunc(basePath+"/api/settings", server.require(PermManageConfig, server.handleAPISettings)).Methods("GET")
+	r.HandleFunc(basePath+"/api/settings", server.require(PermManageConfig, server.handleAPIUpdateSettings)).Methods("PATCH")
+	r.HandleFunc(basePath+"/api/lockouts", server.require(PermManageUsers, server.handleAPILockouts)).Methods("GET")
+	r.HandleFunc(basePath+"/api/lockouts", server.require(PermManageUsers, server.handleAPIClearLockout)).Methods("DELETE")
+
+	// Every state-changing request must carry the session's CSRF token
+	r.Use(server.csrfProtect)
+
+	// Versioned REST API
+	v1 := basePath + "/api/v1"
+	r.HandleFunc(v1+"/status", server.require(PermViewClients, server.handleAPIv1Status)).Methods("GET")
+	r.HandleFunc(v1+"/clients", server.require(PermViewClients, server.handleAPIv1ListClients)).Methods("GET")
+	r.HandleFunc(v1+"/clients", server.require(PermCreateClients, server.handleAPIv1CreateClient)).Methods("POST")
+	r.HandleFunc(v1+"/clients/{id}", server.require(PermViewClients, server.handleAPIv1GetClient)).Methods("GET")
+	r.HandleFunc(v1+"/clients/{id}", server.require(PermViewClients, server.handleAPIv1UpdateClient)).Methods("PATCH")
+	r.HandleFunc(v1+"/clients/{id}", server.require(PermDeleteClients, server.handleAPIv1DeleteClient)).Methods("DELETE")
+	r.HandleFunc(v1+"/clients/{id}/regenerate-keys", server.require(PermEditClients, server.handleAPIv1RegenerateKeys)).Methods("POST")
+	r.HandleFunc(v1+"/clients/{id}/config", server.require(PermDownloadConfigs, server.handleAPIv1ClientConfig)).Methods("GET")
+	r.HandleFunc(v1+"/clients/{id}/portforwards", server.require(PermViewPortForwards, server.handleAPIv1ClientPortForwards)).Methods("GET")
+	r.HandleFunc(v1+"/clients/{id}/portforwards", server.require(PermManagePortForwards, server.handleAPIv1CreatePortForward)).Methods("POST")
+	r.HandleFunc(v1+"/clients/{id}/portforwards/{port}/{protocol}", server.require(PermManagePortForwards, server.handleAPIv1DeletePortForward)).Methods("DELETE")
+	r.HandleFunc(v1+"/portforwards", server.require(PermViewPortForwards, server.handleAPIv1ListPortForwards)).Methods("GET")
+
+	// Prometheus metrics, unless served on their own listener
+	if config.MetricsEnabled && config.MetricsListenAddr == "" {
+		r.HandleFunc(basePath+"/metrics", server.handleMetrics).Methods("GET")
+	}
 
 	// Redirect root to base path if base path is set
 	if basePath != "" {
@@ -73,15 +183,65 @@ func main() {
 		})
 	}
 
-	log.Printf("Starting WireGuard Easy on %s", config.ListenAddr)
-	if basePath != "" {
-		log.Printf("Access the UI at: http://localhost%s%s/", config.ListenAddr, basePath)
-	} else {
-		log.Printf("Access the UI at: http://localhost%s/", config.ListenAddr)
+	slog.Info("Starting WireGuard Easy", "listen_addr", config.ListenAddr, "base_path", basePath+"/")
+	if config.AdminPasswordHash == "" {
+		slog.Warn("admin_password is stored in plaintext, use admin_password_hash instead (see \"wg-easy-go hash-password\")")
 	}
-	log.Printf("Default admin password: %s", config.AdminPassword)
 
-	if err := http.ListenAndServe(config.ListenAddr, r); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	httpServer := &http.Server{
+		Addr:    config.ListenAddr,
+		Handler: server.accessLog(r),
 	}
+	// End event streams so Shutdown does not wait for them
+	httpServer.RegisterOnShutdown(events.Close)
+
+	serverErr := make(chan error, 2)
+	go func() {
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	var metricsServer *http.Server
+	if config.MetricsEnabled && config.MetricsListenAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.HandleFunc("GET /metrics", server.handleMetrics)
+		metricsServer = &http.Server{
+			Addr:    config.MetricsListenAddr,
+			Handler: metricsMux,
+		}
+		slog.Info("Serving metrics", "listen_addr", config.MetricsListenAddr, "path", "/metrics")
+		go func() {
+			serverErr <- metricsServer.ListenAndServe()
+		}()
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutdown signal received")
+	case err := <-serverErr:
+		slog.Error("Server failed", "error", err)
+		exitCode = 1
+	}
+	stop()
+
+	// Ordered teardown: stop serving HTTP, stop NAT-PMP and persist its
+	// state, then remove (or keep) firewall rules and the interface.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	if err := httpServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("HTTP shutdown failed", "error", err)
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Warn("Metrics server shutdown failed", "error", err)
+		}
+	}
+	cancel()
+
+	pfServer.Cleanup()
+	wgManager.Shutdown()
+	webhooks.Close()
+	auditLog.Close()
+
+	slog.Info("Shutdown complete")
+	os.Exit(exitCode)
 }
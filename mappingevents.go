@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const mappingEventHistory = 500
+
+type MappingEventType string
+
+const (
+	MappingCreated MappingEventType = "created"
+	MappingRenewed MappingEventType = "renewed"
+	MappingExpired MappingEventType = "expired"
+	MappingRemoved MappingEventType = "removed"
+)
+
+// MappingEvent records a change to a port mapping. Mapping is a copy taken
+// at the time of the event.
+type MappingEvent struct {
+	Type    MappingEventType `json:"type"`
+	Time    time.Time        `json:"time"`
+	Reason  string           `json:"reason,omitempty"`
+	Mapping PortMapping      `json:"mapping"`
+}
+
+// mappingEventLog keeps the most recent mapping events in memory, publishes
+// them on the event bus, where webhooks pick them up, and audits NAT-PMP
+// changes.
+type mappingEventLog struct {
+	mu     sync.RWMutex
+	events []MappingEvent
+	bus    *EventBus
+	audit  *AuditLog
+}
+
+func newMappingEventLog(bus *EventBus, audit *AuditLog) *mappingEventLog {
+	return &mappingEventLog{bus: bus, audit: audit}
+}
+
+// newMappingEvent captures a change to a mapping. Code holding the mapping
+// lock collects events and records them after unlocking, so publishing and
+// auditing never delay NAT-PMP requests.
+func newMappingEvent(eventType MappingEventType, mapping *PortMapping, reason string) MappingEvent {
+	return MappingEvent{
+		Type:    eventType,
+		Time:    time.Now(),
+		Reason:  reason,
+		Mapping: *mapping,
+	}
+}
+
+// Record keeps, publishes and audits events. It must not be called with the
+// mapping lock held.
+func (l *mappingEventLog) Record(events ...MappingEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	l.events = append(l.events, events...)
+	if len(l.events) > mappingEventHistory {
+		l.events = l.events[len(l.events)-mappingEventHistory:]
+	}
+	l.mu.Unlock()
+
+	for _, event := range events {
+		l.bus.Publish(Event{
+			Type:     EventType("mapping." + string(event.Type)),
+			ClientIP: event.Mapping.ClientIP,
+			Data:     event,
+		})
+		l.audit.recordMapping(event)
+	}
+}
+
+// Recent returns events newest first. An empty clientIP returns events for
+// all clients.
+func (l *mappingEventLog) Recent(clientIP string, limit int) []MappingEvent {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := make([]MappingEvent, 0)
+	for i := len(l.events) - 1; i >= 0 && len(result) < limit; i-- {
+		if clientIP == "" || l.events[i].Mapping.ClientIP == clientIP {
+			result = append(result, l.events[i])
+		}
+	}
+	return result
+}
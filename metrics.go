@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prometheus metrics in the text exposition format (0.0.4). The few metric
+// types needed here are implemented directly instead of pulling in the
+// client library.
+
+// metrics holds the counters updated throughout the code; gauges are read
+// from the managers when scraped.
+var metrics = struct {
+	natpmpRequests   *counterVec
+	firewallDuration *histogramVec
+	firewallFailures *counterVec
+	logins           *counterVec
+}{
+	natpmpRequests: newCounterVec("wg_easy_natpmp_requests_total",
+		"NAT-PMP requests by opcode and result code.", "opcode", "result"),
+	firewallDuration: newHistogramVec("wg_easy_firewall_command_duration_seconds",
+		"Duration of iptables commands.", []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}, "operation"),
+	firewallFailures: newCounterVec("wg_easy_firewall_command_failures_total",
+		"Failed iptables commands.", "operation"),
+	logins: newCounterVec("wg_easy_logins_total",
+		"Login attempts by method and result.", "method", "result"),
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatLabels renders {name="value",...}; extra pairs are appended.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]float64
+	label  map[string][]string
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: make(map[string]float64),
+		label:  make(map[string][]string),
+	}
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *counterVec) Inc(labelValues ...string) {
+	key := labelKey(labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key]++
+	c.label[key] = labelValues
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, formatLabels(c.labels, c.label[key]), c.values[key])
+	}
+}
+
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+// Observe records a value for the given label values.
+func (h *histogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = series
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.count++
+	series.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labels, "le", fmt.Sprintf("%g", bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, series.labels, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, formatLabels(h.labels, series.labels), series.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, series.labels), series.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeGauge writes a gauge with one sample per label value set.
+func writeGauge(w io.Writer, name, help string, labels []string, samples map[string]float64, sampleLabels map[string][]string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, key := range sortedKeys(samples) {
+		fmt.Fprintf(w, "%s%s %g\n", name, formatLabels(labels, sampleLabels[key]), samples[key])
+	}
+}
+
+// handleMetrics serves the metrics. With metrics_token set, scrapers must
+// send it as a bearer token.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.config.MetricsToken != "" {
+		token, _ := bearerToken(r)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.MetricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.writeMetrics(w)
+}
+
+func (s *Server) writeMetrics(w io.Writer) {
+	clients := s.wg.GetClients()
+
+	states := map[string]float64{"enabled": 0, "disabled": 0}
+	for _, client := range clients {
+		if client.Enabled {
+			states["enabled"]++
+		} else {
+			states["disabled"]++
+		}
+	}
+	writeGauge(w, "wg_easy_clients", "Clients by state.", []string{"state"}, states,
+		map[string][]string{"enabled": {"enabled"}, "disabled": {"disabled"}})
+
+	stats, err := s.wg.peerStats()
+	if err != nil {
+		slog.Warn("Failed to read peer statistics", "error", err)
+	}
+	peerLabels := []string{"client", "name"}
+	rx, tx, age := make(map[string]float64), make(map[string]float64), make(map[string]float64)
+	sampleLabels := make(map[string][]string)
+	now := time.Now()
+	for _, client := range clients {
+		stat, ok := stats[client.PublicKey]
+		if !ok {
+			continue
+		}
+		sampleLabels[client.ID] = []string{client.ID, client.Name}
+		rx[client.ID] = float64(stat.ReceiveBytes)
+		tx[client.ID] = float64(stat.TransmitBytes)
+		if !stat.LastHandshake.IsZero() {
+			age[client.ID] = now.Sub(stat.LastHandshake).Seconds()
+		}
+	}
+	fmt.Fprintf(w, "# HELP wg_easy_peer_receive_bytes_total Bytes received from the peer.\n# TYPE wg_easy_peer_receive_bytes_total counter\n")
+	for _, key := range sortedKeys(rx) {
+		fmt.Fprintf(w, "wg_easy_peer_receive_bytes_total%s %g\n", formatLabels(peerLabels, sampleLabels[key]), rx[key])
+	}
+	fmt.Fprintf(w, "# HELP wg_easy_peer_transmit_bytes_total Bytes sent to the peer.\n# TYPE wg_easy_peer_transmit_bytes_total counter\n")
+	for _, key := range sortedKeys(tx) {
+		fmt.Fprintf(w, "wg_easy_peer_transmit_bytes_total%s %g\n", formatLabels(peerLabels, sampleLabels[key]), tx[key])
+	}
+	writeGauge(w, "wg_easy_peer_last_handshake_age_seconds", "Seconds since the peer's last handshake.", peerLabels, age, sampleLabels)
+
+	mappings := map[string]float64{"tcp": 0, "udp": 0}
+	for _, mapping := range s.pf.GetAllMappings() {
+		mappings[mapping.Protocol]++
+	}
+	writeGauge(w, "wg_easy_port_mappings", "Active port mappings by protocol.", []string{"protocol"}, mappings,
+		map[string][]string{"tcp": {"tcp"}, "udp": {"udp"}})
+
+	metrics.natpmpRequests.write(w)
+	metrics.firewallDuration.write(w)
+	metrics.firewallFailures.write(w)
+	metrics.logins.write(w)
+}
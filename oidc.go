@@ -0,0 +1,560 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" // SHA-384/512 for RS384, ES512, ...
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcClockSkew     = time.Minute
+	oidcJWKSMinReload = time.Minute
+)
+
+// OIDCProvider implements OpenID Connect login with the authorization code
+// flow and PKCE. Only the parts of OIDC needed for login are implemented:
+// discovery, the token endpoint and ID token verification against the
+// provider's JWKS.
+type OIDCProvider struct {
+	config *Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]crypto.PublicKey // key: kid
+	keysAt    time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDCProvider(config *Config) *OIDCProvider {
+	return &OIDCProvider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCProvider) Enabled() bool {
+	return p.config.OIDCIssuer != ""
+}
+
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	wellKnown := strings.TrimSuffix(p.config.OIDCIssuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(wellKnown, &d); err != nil {
+		return nil, fmt.Errorf("discovery failed: %v", err)
+	}
+	if d.Issuer != p.config.OIDCIssuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", d.Issuer, p.config.OIDCIssuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery document is incomplete")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+func (p *OIDCProvider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// oidcLogin holds the per-login values kept in the session between the
+// redirect to the provider and the callback.
+type oidcLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func newOIDCLogin() (*oidcLogin, error) {
+	values := make([]string, 3)
+	for i := range values {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		values[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &oidcLogin{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// AuthCodeURL returns the provider URL to send the browser to.
+func (p *OIDCProvider) AuthCodeURL(login *oidcLogin, redirectURL string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.OIDCClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.config.OIDCScopes, " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token
+// claims.
+func (p *OIDCProvider) Exchange(code string, login *oidcLogin, redirectURL string) (map[string]interface{}, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.config.OIDCClientID},
+		"code_verifier": {login.Verifier},
+	}
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.OIDCClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.OIDCClientID), url.QueryEscape(p.config.OIDCClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("token request failed: %s %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.verifyIDToken(token.IDToken, login.Nonce)
+}
+
+func (p *OIDCProvider) verifyIDToken(rawToken, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed id_token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id_token signature")
+	}
+
+	key, err := p.signingKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed id_token claims: %v", err)
+	}
+
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); iss != p.config.OIDCIssuer {
+		return nil, fmt.Errorf("id_token issuer %q does not match", iss)
+	}
+	if !audienceContains(claims["aud"], p.config.OIDCClientID) {
+		return nil, errors.New("id_token audience does not match client_id")
+	}
+	if exp, ok := claims["exp"].(float64); !ok || now.After(time.Unix(int64(exp), 0).Add(oidcClockSkew)) {
+		return nil, errors.New("id_token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcClockSkew)) {
+		return nil, errors.New("id_token issued in the future")
+	}
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtAlgorithm is an accepted id_token signature algorithm.
+type jwtAlgorithm struct {
+	hash  crypto.Hash
+	curve elliptic.Curve // ECDSA only
+}
+
+var jwtAlgorithms = map[string]jwtAlgorithm{
+	"RS256": {hash: crypto.SHA256},
+	"RS384": {hash: crypto.SHA384},
+	"RS512": {hash: crypto.SHA512},
+	"PS256": {hash: crypto.SHA256},
+	"PS384": {hash: crypto.SHA384},
+	"PS512": {hash: crypto.SHA512},
+	"ES256": {hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {hash: crypto.SHA384, curve: elliptic.P384()},
+	"ES512": {hash: crypto.SHA512, curve: elliptic.P521()},
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	algorithm, ok := jwtAlgorithms[alg]
+	if !ok {
+		return fmt.Errorf("unsupported id_token algorithm %q", alg)
+	}
+	h := algorithm.hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("id_token key type does not match algorithm")
+		}
+		if err := rsa.VerifyPKCS1v15(pub, algorithm.hash, digest, signature); err != nil {
+			return errors.New("invalid id_token signature")
+		}
+	case "PS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("id_token key type does not match algorithm")
+		}
+		if err := rsa.VerifyPSS(pub, algorithm.hash, digest, signature, nil); err != nil {
+			return errors.New("invalid id_token signature")
+		}
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().Name != algorithm.curve.Params().Name {
+			return errors.New("id_token key type does not match algorithm")
+		}
+		// R and S, each padded to the curve's byte size (RFC 7518 3.4)
+		size := (algorithm.curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid id_token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid id_token signature")
+		}
+	}
+	return nil
+}
+
+// signingKey returns the provider key with the given kid, reloading the JWKS
+// (at most once a minute) when the key is unknown, e.g. after key rotation.
+func (p *OIDCProvider) signingKey(kid string) (crypto.PublicKey, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysAt) < oidcJWKSMinReload {
+		return nil, fmt.Errorf("unknown id_token key %q", kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(d.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to load JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{
+				Curve: curve,
+				X:     new(big.Int).SetBytes(x),
+				Y:     new(big.Int).SetBytes(y),
+			}
+		}
+	}
+	p.keys = keys
+	p.keysAt = time.Now()
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown id_token key %q", kid)
+}
+
+// lookupKey must be called with p.mu held. Tokens without a kid are accepted
+// when the provider publishes a single key.
+func (p *OIDCProvider) lookupKey(kid string) crypto.PublicKey {
+	if key, ok := p.keys[kid]; ok {
+		return key
+	}
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// MapUser turns verified ID token claims into a session user. The most
+// privileged role matched by oidc_role_mapping wins; without a match
+// oidc_default_role is used, and if that is empty the login is refused.
+func (p *OIDCProvider) MapUser(claims map[string]interface{}) (*User, error) {
+	username, _ := claims[p.config.OIDCUsernameClaim].(string)
+	if username == "" {
+		username, _ = claims["sub"].(string)
+	}
+	if username == "" {
+		return nil, errors.New("id_token has no username")
+	}
+
+	var values []string
+	switch v := claims[p.config.OIDCRolesClaim].(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	role := mapRole(values, p.config.OIDCRoleMapping, p.config.OIDCDefaultRole)
+	if role == "" {
+		slog.Warn("OIDC login refused, no role mapped", "user", username, "claim", p.config.OIDCRolesClaim, "values", values)
+		return nil, errors.New("your account is not authorized for this application")
+	}
+
+	return &User{Username: oidcUserPrefix + username, Role: role, External: true}, nil
+}
+
+const oidcLoginSession = "oidc_login"
+
+// oidcRedirectURL returns the callback URL registered with the provider.
+func (s *Server) oidcRedirectURL(r *http.Request) string {
+	if s.config.OIDCRedirectURL != "" {
+		return s.config.OIDCRedirectURL
+	}
+	return s.externalURL(r, "/login/oidc/callback")
+}
+
+func (s *Server) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !s.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	login, err := newOIDCLogin()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	authURL, err := s.oidc.AuthCodeURL(login, s.oidcRedirectURL(r))
+	if err != nil {
+		requestLogger(r).Error("OIDC login failed", "error", err)
+		s.renderLogin(w, r, "Single sign-on is currently unavailable")
+		return
+	}
+
+	session, _ := s.store.Get(r, oidcLoginSession)
+	session.Options.MaxAge = 600 // the login must finish within 10 minutes
+	session.Values["state"] = login.State
+	session.Values["nonce"] = login.Nonce
+	session.Values["verifier"] = login.Verifier
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (s *Server) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !s.oidc.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+
+	loginSession, _ := s.store.Get(r, oidcLoginSession)
+	state, _ := loginSession.Values["state"].(string)
+	nonce, _ := loginSession.Values["nonce"].(string)
+	verifier, _ := loginSession.Values["verifier"].(string)
+	loginSession.Options.MaxAge = -1
+	loginSession.Save(r, w)
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		requestLogger(r).Warn("Login failed at OIDC provider", "remote_ip", s.clientIP(r), "method", "oidc",
+			"error", errCode, "error_description", r.URL.Query().Get("error_description"))
+		metrics.logins.Inc("oidc", "failure")
+		s.auditLogin(r, "", "oidc", "failure", errCode)
+		s.renderLogin(w, r, "Single sign-on failed")
+		return
+	}
+	if state == "" || r.URL.Query().Get("state") != state {
+		requestLogger(r).Warn("Login failed, OIDC state mismatch", "remote_ip", s.clientIP(r), "method", "oidc")
+		metrics.logins.Inc("oidc", "failure")
+		s.auditLogin(r, "", "oidc", "failure", "state mismatch")
+		s.renderLogin(w, r, "Single sign-on failed, please try again")
+		return
+	}
+
+	login := &oidcLogin{State: state, Nonce: nonce, Verifier: verifier}
+	claims, err := s.oidc.Exchange(r.URL.Query().Get("code"), login, s.oidcRedirectURL(r))
+	if err != nil {
+		requestLogger(r).Warn("Login failed", "remote_ip", s.clientIP(r), "method", "oidc", "error", err)
+		metrics.logins.Inc("oidc", "failure")
+		s.auditLogin(r, "", "oidc", "failure", err.Error())
+		s.renderLogin(w, r, "Single sign-on failed")
+		return
+	}
+	user, err := s.oidc.MapUser(claims)
+	if err != nil {
+		metrics.logins.Inc("oidc", "failure")
+		username, _ := claims[s.config.OIDCUsernameClaim].(string)
+		if username != "" {
+			username = oidcUserPrefix + username
+		}
+		s.auditLogin(r, username, "oidc", "failure", err.Error())
+		s.renderLogin(w, r, err.Error())
+		return
+	}
+
+	session, _ := s.store.Get(r, "session")
+	session.Values["username"] = user.Username
+	delete(session.Values, csrfSessionKey)
+	session.Values["auth"] = "oidc"
+	session.Values["role"] = string(user.Role)
+	session.Save(r, w)
+	requestLogger(r).Info("User logged in", "user", user.Username, "role", user.Role, "remote_ip", s.clientIP(r), "method", "oidc")
+	metrics.logins.Inc("oidc", "success")
+	s.auditLogin(r, user.Username, "oidc", "success", "")
+	s.redirect(w, r, "/")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockIssuer is an OIDC provider serving discovery, JWKS and a token
+// endpoint that answers with idToken.
+type mockIssuer struct {
+	server  *httptest.Server
+	rsaKey  *rsa.PrivateKey
+	ecKeys  map[string]*ecdsa.PrivateKey // kid -> key
+	idToken string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+	m := &mockIssuer{ecKeys: make(map[string]*ecdsa.PrivateKey)}
+
+	var err error
+	if m.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	for kid, curve := range map[string]elliptic.Curve{"ec256": elliptic.P256(), "ec384": elliptic.P384()} {
+		if m.ecKeys[kid], err = ecdsa.GenerateKey(curve, rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		keys := []map[string]string{{
+			"kty": "RSA", "kid": "rsa", "use": "sig",
+			"n": b64(m.rsaKey.N.Bytes()),
+			"e": b64(big.NewInt(int64(m.rsaKey.E)).Bytes()),
+		}}
+		for kid, key := range m.ecKeys {
+			keys = append(keys, map[string]string{
+				"kty": "EC", "kid": kid, "crv": key.Curve.Params().Name,
+				"x": b64(key.X.Bytes()), "y": b64(key.Y.Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "authorization_code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.idToken})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// sign returns a JWT with the given header alg and kid, signed with the
+// matching mock key. The signature algorithm follows signAlg, so tokens
+// whose header lies about the algorithm can be built.
+func (m *mockIssuer) sign(t *testing.T, alg, kid, signAlg string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	var err error
+	switch signAlg {
+	case "RS256", "PS256":
+		digest := crypto.SHA256.New()
+		digest.Write([]byte(signed))
+		if signAlg == "RS256" {
+			signature, err = rsa.SignPKCS1v15(rand.Reader, m.rsaKey, crypto.SHA256, digest.Sum(nil))
+		} else {
+			signature, err = rsa.SignPSS(rand.Reader, m.rsaKey, crypto.SHA256, digest.Sum(nil), nil)
+		}
+	case "ES256", "ES384":
+		hash := map[string]crypto.Hash{"ES256": crypto.SHA256, "ES384": crypto.SHA384}[signAlg]
+		key := m.ecKeys[kid]
+		digest := hash.New()
+		digest.Write([]byte(signed))
+		var r, s *big.Int
+		if r, s, err = ecdsa.Sign(rand.Reader, key, digest.Sum(nil)); err == nil {
+			size := (key.Curve.Params().BitSize + 7) / 8
+			signature = make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			s.FillBytes(signature[size:])
+		}
+	case "":
+		signature = []byte("unsigned")
+	default:
+		t.Fatalf("unknown signing algorithm %q", signAlg)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCExchangeVerifiesIDToken(t *testing.T) {
+	issuer := newMockIssuer(t)
+	config := &Config{OIDCIssuer: issuer.server.URL, OIDCClientID: "wg-easy", OIDCUsernameClaim: "preferred_username"}
+
+	claims := func(nonce string, change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":                issuer.server.URL,
+			"aud":                "wg-easy",
+			"sub":                "1234",
+			"preferred_username": "alice",
+			"nonce":              nonce,
+			"iat":                time.Now().Unix(),
+			"exp":                time.Now().Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		alg     string
+		kid     string
+		signAlg string
+		change  func(map[string]interface{})
+		wantErr string
+	}{
+		{name: "RS256", alg: "RS256", kid: "rsa", signAlg: "RS256"},
+		{name: "PS256", alg: "PS256", kid: "rsa", signAlg: "PS256"},
+		{name: "ES256", alg: "ES256", kid: "ec256", signAlg: "ES256"},
+		{name: "ES384", alg: "ES384", kid: "ec384", signAlg: "ES384"},
+		{name: "empty alg", alg: "", kid: "rsa", signAlg: "RS256", wantErr: "unsupported id_token algorithm"},
+		{name: "short alg", alg: "X", kid: "rsa", signAlg: "RS256", wantErr: "unsupported id_token algorithm"},
+		{name: "alg none", alg: "none", kid: "rsa", signAlg: "", wantErr: "unsupported id_token algorithm"},
+		{name: "HMAC alg", alg: "HS256", kid: "rsa", signAlg: "RS256", wantErr: "unsupported id_token algorithm"},
+		{name: "unknown suffix", alg: "RS999", kid: "rsa", signAlg: "RS256", wantErr: "unsupported id_token algorithm"},
+		{name: "ES384 header on P-256 key", alg: "ES384", kid: "ec256", signAlg: "ES256", wantErr: "does not match algorithm"},
+		{name: "ES256 header on P-384 key", alg: "ES256", kid: "ec384", signAlg: "ES384", wantErr: "does not match algorithm"},
+		{name: "EC alg on RSA key", alg: "ES256", kid: "rsa", signAlg: "RS256", wantErr: "does not match algorithm"},
+		{name: "RS256 signature as PS256", alg: "PS256", kid: "rsa", signAlg: "RS256", wantErr: "invalid id_token signature"},
+		{name: "unknown kid", alg: "RS256", kid: "other", signAlg: "RS256", wantErr: "unknown id_token key"},
+		{name: "wrong issuer", alg: "RS256", kid: "rsa", signAlg: "RS256",
+			change: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, wantErr: "issuer"},
+		{name: "wrong audience", alg: "RS256", kid: "rsa", signAlg: "RS256",
+			change: func(c map[string]interface{}) { c["aud"] = []string{"other"} }, wantErr: "audience"},
+		{name: "expired", alg: "RS256", kid: "rsa", signAlg: "RS256",
+			change: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "expired"},
+		{name: "wrong nonce", alg: "RS256", kid: "rsa", signAlg: "RS256",
+			change: func(c map[string]interface{}) { c["nonce"] = "replayed" }, wantErr: "nonce"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewOIDCProvider(config)
+			login, err := newOIDCLogin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			issuer.idToken = issuer.sign(t, tt.alg, tt.kid, tt.signAlg, claims(login.Nonce, tt.change))
+
+			got, err := provider.Exchange("code", login, "https://vpn.example.com/wgeasy/oidc/callback")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Exchange() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if got["preferred_username"] != "alice" {
+				t.Errorf("claims = %v", got)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSignatureRejectsMalformedAlg(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, alg := range []string{"", "X", "56", "ES", "none", "ES256K"} {
+		if err := verifyJWTSignature(alg, &key.PublicKey, "a.b", make([]byte, 64)); err == nil {
+			t.Errorf("verifyJWTSignature(%q) accepted the token", alg)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPI 3 description of the JSON API, served at /api/openapi.json.
+//
+// Schemas are generated from the Go types the handlers encode and decode, so
+// the document follows their JSON tags instead of being maintained by hand.
+
+// apiOperation describes one JSON route. Request and Response are zero values
+// of the body types; nil means no body.
+type apiOperation struct {
+	ID          string // operationId
+	Method      string
+	Path        string // relative to BasePath, with {param} placeholders
+	Tag         string
+	Summary     string
+	Permission  Permission // empty: any authenticated user
+	Query       []string   // optional query parameters
+	Request     interface{}
+	Status      int
+	Response    interface{}
+	ContentType string // response content type, default application/json
+}
+
+type csrfTokenResponse struct {
+	CSRFToken string `json:"csrf_token"`
+}
+
+var apiOperations = []apiOperation{
+	{ID: "getCSRFToken", Method: "GET", Path: "/api/csrf", Tag: "session", Summary: "Get the session's CSRF token",
+		Status: http.StatusOK, Response: csrfTokenResponse{}},
+
+	{ID: "legacyListClients", Method: "GET", Path: "/api/clients", Tag: "clients", Summary: "List clients (legacy)", Permission: PermViewClients,
+		Status: http.StatusOK, Response: []WireGuardClient{}},
+	{ID: "legacyListClientPortForwards", Method: "GET", Path: "/api/clients/{id}/portforwards", Tag: "portforwards", Summary: "List a client's port forwards (legacy)", Permission: PermViewPortForwards,
+		Status: http.StatusOK, Response: []PortMapping{}},
+	{ID: "legacyListPortForwards", Method: "GET", Path: "/api/portforwards", Tag: "portforwards", Summary: "List port forwards (legacy)", Permission: PermViewPortForwards,
+		Status: http.StatusOK, Response: []PortMapping{}},
+	{ID: "streamEvents", Method: "GET", Path: "/api/events", Tag: "events", Summary: "Stream client, peer and port forward events (Server-Sent Events)", Permission: PermViewClients,
+		Status: http.StatusOK, Response: Event{}, ContentType: "text/event-stream"},
+	{ID: "listPortForwardEvents", Method: "GET", Path: "/api/portforwards/events", Tag: "portforwards", Summary: "List recent port forward events", Permission: PermViewPortForwards,
+		Query: []string{"client_ip"}, Status: http.StatusOK, Response: []MappingEvent{}},
+
+	{ID: "listUsers", Method: "GET", Path: "/api/users", Tag: "users", Summary: "List users", Permission: PermManageUsers,
+		Status: http.StatusOK, Response: []User{}},
+	{ID: "createUser", Method: "POST", Path: "/api/users", Tag: "users", Summary: "Create a user", Permission: PermManageUsers,
+		Request: userRequest{}, Status: http.StatusCreated, Response: User{}},
+	{ID: "updateUser", Method: "PATCH", Path: "/api/users/{username}", Tag: "users", Summary: "Change a user's role or password", Permission: PermManageUsers,
+		Request: userRequest{}, Status: http.StatusOK, Response: User{}},
+	{ID: "deleteUser", Method: "DELETE", Path: "/api/users/{username}", Tag: "users", Summary: "Delete a user", Permission: PermManageUsers,
+		Status: http.StatusNoContent},
+
+	{ID: "listTokens", Method: "GET", Path: "/api/tokens", Tag: "tokens", Summary: "List API tokens",
+		Status: http.StatusOK, Response: []APIToken{}},
+	{ID: "createToken", Method: "POST", Path: "/api/tokens", Tag: "tokens", Summary: "Create an API token",
+		Request: tokenRequest{}, Status: http.StatusCreated, Response: createdToken{}},
+	{ID: "revokeToken", Method: "DELETE", Path: "/api/tokens/{id}", Tag: "tokens", Summary: "Revoke an API token",
+		Status: http.StatusNoContent},
+
+	{ID: "listWebhookDeliveries", Method: "GET", Path: "/api/webhooks/deliveries", Tag: "webhooks", Summary: "List queued and finished webhook deliveries", Permission: PermManageWebhooks,
+		Status: http.StatusOK, Response: webhookDeliveries{}},
+	{ID: "retryWebhookDelivery", Method: "POST", Path: "/api/webhooks/deliveries/{id}/retry", Tag: "webhooks", Summary: "Retry or redeliver a webhook delivery", Permission: PermManageWebhooks,
+		Status: http.StatusAccepted},
+	{ID: "listLockouts", Method: "GET", Path: "/api/lockouts", Tag: "users", Summary: "List failed logins and lockouts", Permission: PermManageUsers,
+		Status: http.StatusOK, Response: []LoginLockout{}},
+	{ID: "clearLockout", Method: "DELETE", Path: "/api/lockouts", Tag: "users", Summary: "Clear a lockout", Permission: PermManageUsers,
+		Query: []string{"key"}, Status: http.StatusNoContent},
+	{ID: "listAuditEntries", Method: "GET", Path: "/api/audit", Tag: "audit", Summary: "List audit log entries, newest first", Permission: PermViewAudit,
+		Query: []string{"actor", "action", "target", "since", "until", "page", "per_page"}, Status: http.StatusOK, Response: auditPage{}},
+	{ID: "reloadConfig", Method: "POST", Path: "/api/config/reload", Tag: "config", Summary: "Re-read the configuration and apply the settings that do not need a restart", Permission: PermManageConfig,
+		Status: http.StatusOK, Response: ReloadResult{}},
+	{ID: "getSettings", Method: "GET", Path: "/api/settings", Tag: "config", Summary: "Get the server settings, in effect and from the config", Permission: PermManageConfig,
+		Status: http.StatusOK, Response: settingsResponse{}},
+	{ID: "updateSettings", Method: "PATCH", Path: "/api/settings", Tag: "config", Summary: "Change server settings; null restores the config value", Permission: PermManageConfig,
+		Request: ServerSettings{}, Status: http.StatusOK, Response: settingsResponse{}},
+
+	{ID: "getStatus", Method: "GET", Path: "/api/v1/status", Tag: "v1", Summary: "Server status", Permission: PermViewClients,
+		Status: http.StatusOK, Response: serverStatus{}},
+	{ID: "listClients", Method: "GET", Path: "/api/v1/clients", Tag: "v1", Summary: "List clients", Permission: PermViewClients,
+		Status: http.StatusOK, Response: []WireGuardClient{}},
+	{ID: "createClient", Method: "POST", Path: "/api/v1/clients", Tag: "v1", Summary: "Create a client", Permission: PermCreateClients,
+		Request: createClientRequest{}, Status: http.StatusCreated, Response: WireGuardClient{}},
+	{ID: "getClient", Method: "GET", Path: "/api/v1/clients/{id}", Tag: "v1", Summary: "Get a client", Permission: PermViewClients,
+		Status: http.StatusOK, Response: WireGuardClient{}},
+	{ID: "updateClient", Method: "PATCH", Path: "/api/v1/clients/{id}", Tag: "v1", Summary: "Rename, enable/disable or change a client's allowed IPs", Permission: PermViewClients,
+		Request: updateClientRequest{}, Status: http.StatusOK, Response: WireGuardClient{}},
+	{ID: "deleteClient", Method: "DELETE", Path: "/api/v1/clients/{id}", Tag: "v1", Summary: "Delete a client", Permission: PermDeleteClients,
+		Status: http.StatusNoContent},
+	{ID: "regenerateClientKeys", Method: "POST", Path: "/api/v1/clients/{id}/regenerate-keys", Tag: "v1", Summary: "Replace a client's key pair", Permission: PermEditClients,
+		Status: http.StatusOK, Response: WireGuardClient{}},
+	{ID: "getClientConfig", Method: "GET", Path: "/api/v1/clients/{id}/config", Tag: "v1", Summary: "Download a client's WireGuard config", Permission: PermDownloadConfigs,
+		Status: http.StatusOK, Response: "", ContentType: "text/plain"},
+	{ID: "listClientPortForwards", Method: "GET", Path: "/api/v1/clients/{id}/portforwards", Tag: "v1", Summary: "List a client's port forwards", Permission: PermViewPortForwards,
+		Status: http.StatusOK, Response: []PortMapping{}},
+	{ID: "createPortForward", Method: "POST", Path: "/api/v1/clients/{id}/portforwards", Tag: "v1", Summary: "Add a port forward", Permission: PermManagePortForwards,
+		Request: createPortForwardRequest{}, Status: http.StatusCreated, Response: PortMapping{}},
+	{ID: "deletePortForward", Method: "DELETE", Path: "/api/v1/clients/{id}/portforwards/{port}/{protocol}", Tag: "v1", Summary: "Remove a port forward", Permission: PermManagePortForwards,
+		Status: http.StatusNoContent},
+	{ID: "listPortForwards", Method: "GET", Path: "/api/v1/portforwards", Tag: "v1", Summary: "List port forwards", Permission: PermViewPortForwards,
+		Status: http.StatusOK, Response: []PortMapping{}},
+}
+
+// openAPIEnums lists the values of string types with a fixed set of values.
+var openAPIEnums = map[reflect.Type][]string{
+	reflect.TypeOf(Role("")):             enumStrings(Roles),
+	reflect.TypeOf(TokenScope("")):       enumStrings(TokenScopes),
+	reflect.TypeOf(MappingEventType("")): enumStrings([]MappingEventType{MappingCreated, MappingRenewed, MappingExpired, MappingRemoved}),
+	reflect.TypeOf(EventType("")):        enumStrings(append(EventTypes, EventWebhookTest)),
+}
+
+// openAPIHidden lists fields that are stored but never returned by the API.
+var openAPIHidden = map[string]bool{
+	"User.password_hash": true,
+	"APIToken.hash":      true,
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+func enumStrings[T ~string](values []T) []string {
+	result := make([]string, len(values))
+	for i, v := range values {
+		result[i] = string(v)
+	}
+	return result
+}
+
+func (s *Server) handleAPIOpenAPI(w http.ResponseWriter, r *http.Request) {
+	server := s.basePath(r)
+	if server == "" {
+		server = "/"
+	}
+	writeJSON(w, http.StatusOK, openAPIDocument(server))
+}
+
+// openAPIDocument builds the OpenAPI document for the given server URL.
+func openAPIDocument(serverURL string) map[string]interface{} {
+	schemas := make(map[string]interface{})
+	paths := make(map[string]interface{})
+
+	for _, op := range apiOperations {
+		operation := map[string]interface{}{
+			"summary":     op.Summary,
+			"operationId": op.ID,
+			"tags":        []string{op.Tag},
+		}
+		if op.Permission != "" {
+			operation["description"] = "Requires the " + string(op.Permission) + " permission."
+		}
+
+		var params []interface{}
+		for _, match := range pathParamPattern.FindAllStringSubmatch(op.Path, -1) {
+			params = append(params, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, name := range op.Query {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "query",
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": openAPISchema(reflect.TypeOf(op.Request), schemas),
+					},
+				},
+			}
+		}
+
+		success := map[string]interface{}{"description": http.StatusText(op.Status)}
+		if op.Response != nil {
+			contentType := op.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			success["content"] = map[string]interface{}{
+				contentType: map[string]interface{}{
+					"schema": openAPISchema(reflect.TypeOf(op.Response), schemas),
+				},
+			}
+		}
+		operation["responses"] = map[string]interface{}{
+			strconv.Itoa(op.Status): success,
+			"default":               map[string]interface{}{"$ref": "#/components/responses/Error"},
+		}
+
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	schemas["Error"] = map[string]interface{}{
+		"type":     "object",
+		"required": []string{"error", "code"},
+		"properties": map[string]interface{}{
+			"error": map[string]interface{}{"type": "string", "description": "Human-readable message"},
+			"code":  map[string]interface{}{"type": "string", "description": "Status text in snake case, e.g. not_found"},
+		},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "WireGuard Easy API",
+			"version": "1",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": serverURL}},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+			map[string]interface{}{"sessionCookie": []string{}},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content": map[string]interface{}{
+						"application/json": map[string]interface{}{
+							"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+						},
+					},
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "API token (wge_...)",
+				},
+				"sessionCookie": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        "session",
+					"description": "Browser session; state-changing requests also need the X-CSRF-Token header",
+				},
+			},
+		},
+	}
+}
+
+// openAPISchema returns the schema of t. Named structs are added to schemas
+// and referenced.
+func openAPISchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	if values, ok := openAPIEnums[t]; ok {
+		return map[string]interface{}{"type": "string", "enum": values}
+	}
+	if t == reflect.TypeOf(json.RawMessage(nil)) {
+		return map[string]interface{}{} // any JSON value
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := openAPISchema(t.Elem(), schemas)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": openAPISchema(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": openAPISchema(t.Elem(), schemas)}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+	default:
+		return map[string]interface{}{}
+	}
+
+	if t.Name() == "" {
+		return structSchema(t, schemas)
+	}
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	if _, ok := schemas[name]; !ok {
+		schemas[name] = nil // placeholder for recursive types
+		schemas[name] = structSchema(t, schemas)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// structSchema describes a struct's JSON encoding. Fields without omitempty
+// or omitzero are required.
+func structSchema(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		if openAPIHidden[t.Name()+"."+name] {
+			continue
+		}
+
+		properties[name] = openAPISchema(field.Type, schemas)
+		if !strings.Contains(options, "omitempty") && !strings.Contains(options, "omitzero") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// Port allocation strategies for port_forward_allocation.
+const (
+	// AllocationPreserve honours the requested external port and falls back
+	// to a random free port when it is unavailable.
+	AllocationPreserve = "preserve"
+	// AllocationRandom ignores the requested external port and always picks
+	// a random free port, which makes forwarded ports harder to scan for.
+	AllocationRandom = "random"
+	// AllocationSequential honours the requested external port and falls
+	// back to the lowest free port in range.
+	AllocationSequential = "sequential"
+)
+
+const (
+	portLeasesFile = "portforward-leases.json"
+	portLeaseTTL   = 30 * 24 * time.Hour
+
+	// portLeaseRefresh is how stale LastUsed may get before a renewal saves
+	// it, so renewals do not rewrite the leases file every time
+	portLeaseRefresh = 24 * time.Hour
+)
+
+var errNoFreePort = errors.New("no free port")
+
+// errMappingLimit is returned when a client already has
+// port_forward_max_per_client mappings.
+var errMappingLimit = errors.New("port forward limit reached")
+
+// portLease remembers the external port last assigned to a client's internal
+// port, so the same port is handed out again on renewal, after expiry and
+// across restarts.
+type portLease struct {
+	ClientIP     string    `json:"client_ip"`
+	InternalPort uint16    `json:"internal_port"`
+	Protocol     string    `json:"protocol"`
+	ExternalPort uint16    `json:"external_port"`
+	LastUsed     time.Time `json:"last_used"`
+}
+
+// leaseKey identifies a lease: "clientIP:internalPort:protocol"
+func leaseKey(clientIP string, internalPort uint16, protocol string) string {
+	return fmt.Sprintf("%s:%d:%s", clientIP, internalPort, protocol)
+}
+
+func (pfs *PortForwardServer) loadPortLeases() {
+	leases := make(map[string]*portLease)
+	if err := loadState(pfs.config, portLeasesFile, &leases); err != nil {
+		slog.Warn("Failed to load port leases", "error", err)
+		return
+	}
+
+	now := time.Now()
+	for key, lease := range leases {
+		if now.Sub(lease.LastUsed) > portLeaseTTL {
+			delete(leases, key)
+		}
+	}
+	pfs.leases = leases
+}
+
+// savePortLeases must be called with pfs.mu held.
+func (pfs *PortForwardServer) savePortLeases() {
+	if err := saveState(pfs.config, portLeasesFile, pfs.leases); err != nil {
+		slog.Warn("Failed to save port leases", "error", err)
+	}
+}
+
+// rememberPort records the external port of a lease. The leases file is only
+// written when the lease is new, changes port or its LastUsed is older than
+// portLeaseRefresh. Must be called with pfs.mu held.
+func (pfs *PortForwardServer) rememberPort(clientIP string, externalPort, internalPort uint16, protocol string) {
+	key := leaseKey(clientIP, internalPort, protocol)
+	if lease, ok := pfs.leases[key]; ok && lease.ExternalPort == externalPort && time.Since(lease.LastUsed) < portLeaseRefresh {
+		return
+	}
+	pfs.leases[key] = &portLease{
+		ClientIP:     clientIP,
+		InternalPort: internalPort,
+		Protocol:     protocol,
+		ExternalPort: externalPort,
+		LastUsed:     time.Now(),
+	}
+	pfs.savePortLeases()
+}
+
+// takenPorts returns the external ports that cannot be assigned to the given
+// lease: ports used by any other mapping and ports leased to anyone else.
+// Must be called with pfs.mu held.
+func (pfs *PortForwardServer) takenPorts(clientIP string, internalPort uint16, protocol string) map[uint16]bool {
+	taken := make(map[uint16]bool)
+	for _, mapping := range pfs.mappings {
+		if mapping.Protocol != protocol {
+			continue
+		}
+		if mapping.ClientIP == clientIP && mapping.InternalPort == internalPort {
+			continue
+		}
+		taken[mapping.ExternalPort] = true
+	}
+
+	own := leaseKey(clientIP, internalPort, protocol)
+	for key, lease := range pfs.leases {
+		if key != own && lease.Protocol == protocol {
+			taken[lease.ExternalPort] = true
+		}
+	}
+	return taken
+}
+
+// allocatePort picks the external port for a mapping request. An existing
+// mapping or lease for the same client port wins, then the requested port
+// (unless the strategy is random), then a free port chosen by the strategy.
+// Must be called with pfs.mu held.
+func (pfs *PortForwardServer) allocatePort(clientIP string, requestedPort, internalPort uint16, protocol string) (uint16, error) {
+	config := pfs.settings.Current()
+	minPort, maxPort := config.PortForwardMinPort, config.PortForwardMaxPort
+	taken := pfs.takenPorts(clientIP, internalPort, protocol)
+
+	usable := func(port uint16) bool {
+		return port != 0 && port >= minPort && port <= maxPort && !taken[port] &&
+			!pfs.reserved.IsReserved(port, protocol)
+	}
+
+	// Renewal of an active mapping keeps its port
+	for _, mapping := range pfs.mappings {
+		if mapping.ClientIP == clientIP && mapping.InternalPort == internalPort && mapping.Protocol == protocol {
+			return mapping.ExternalPort, nil
+		}
+	}
+
+	if config.PortForwardAllocation != AllocationRandom && usable(requestedPort) {
+		return requestedPort, nil
+	}
+
+	if lease, ok := pfs.leases[leaseKey(clientIP, internalPort, protocol)]; ok && usable(lease.ExternalPort) {
+		return lease.ExternalPort, nil
+	}
+
+	span := int(maxPort) - int(minPort) + 1
+	offset := 0
+	if config.PortForwardAllocation != AllocationSequential {
+		offset = rand.IntN(span)
+	}
+
+	for i := 0; i < span; i++ {
+		port := minPort + uint16((offset+i)%span)
+		if usable(port) {
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: all %s ports in range %d-%d are taken", errNoFreePort, protocol, minPort, maxPort)
+}
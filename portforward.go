@@ -1,15 +1,42 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os/exec"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// NAT-PMP result codes (RFC 6886 section 3.5)
+const (
+	natpmpResultSuccess            = 0
+	natpmpResultUnsupportedVersion = 1
+	natpmpResultNotAuthorized      = 2
+	natpmpResultNetworkFailure     = 3
+	natpmpResultOutOfResources     = 4
+	natpmpResultUnsupportedOpcode  = 5
+)
+
+const portMappingsFile = "portforward-mappings.json"
+
+// Description of mappings requested over NAT-PMP and reason of their
+// removal by the client
+const (
+	natpmpDescription  = "NAT-PMP"
+	natpmpRemoveReason = "client request"
+)
+
+// externalIPCheckInterval is how often a wg_endpoint hostname is resolved
+// again to notice a changed public IP.
+const externalIPCheckInterval = 5 * time.Minute
+
 type PortMapping struct {
 	ClientIP     string    `json:"client_ip"`
 	ExternalPort uint16    `json:"external_port"`
@@ -19,70 +46,162 @@ type PortMapping struct {
 	Lifetime     uint32    `json:"lifetime"`
 	CreatedAt    time.Time `json:"created_at"`
 	ExpiresAt    time.Time `json:"expires_at"`
+	RenewalCount int       `json:"renewal_count"`
+	LastRenewed  time.Time `json:"last_renewed"`
 }
 
 type PortForwardServer struct {
 	config     *Config
+	settings   *SettingsStore
 	mappings   map[string]*PortMapping // key: "clientIP:externalPort:protocol"
+	leases     map[string]*portLease   // key: "clientIP:internalPort:protocol"
+	reserved   *ReservedPorts
+	events     *mappingEventLog
+	expiry     expiryQueue
+	expiryWake chan struct{}
 	mu         sync.RWMutex
+	ctx        context.Context
+	cancel     context.CancelFunc
 	natpmpConn *net.UDPConn
-	externalIP string
+	externalIP string // guarded by mu
+	bus        *EventBus
 	enabled    bool
 }
 
-func NewPortForwardServer(config *Config) *PortForwardServer {
+// NewPortForwardServer starts the NAT-PMP server. Its goroutines stop when ctx
+// is cancelled or Cleanup is called.
+func NewPortForwardServer(ctx context.Context, config *Config, settings *SettingsStore, bus *EventBus, audit *AuditLog) *PortForwardServer {
 	pfs := &PortForwardServer{
-		config:   config,
-		mappings: make(map[string]*PortMapping),
-		enabled:  config.PortForwardEnabled,
-	}
+		config:     config,
+		settings:   settings,
+		mappings:   make(map[string]*PortMapping),
+		leases:     make(map[string]*portLease),
+		reserved:   NewReservedPorts(config),
+		events:     newMappingEventLog(bus, audit),
+		bus:        bus,
+		expiryWake: make(chan struct{}, 1),
+		enabled:    config.PortForwardEnabled,
+	}
+	pfs.ctx, pfs.cancel = context.WithCancel(ctx)
 
 	if !pfs.enabled {
-		log.Println("Port forwarding server is disabled in config")
+		slog.Info("Port forwarding server is disabled in config")
 		return pfs
 	}
 
 	// Get external IP (server's public IP)
-	pfs.externalIP = config.WgEndpoint
-	if host, _, err := net.SplitHostPort(config.WgEndpoint); err == nil {
-		pfs.externalIP = host
-	}
-
-	// Resolve domain to IP if needed
-	if ip := net.ParseIP(pfs.externalIP); ip == nil {
-		// It's a domain name, resolve it
-		ips, err := net.LookupIP(pfs.externalIP)
-		if err != nil {
-			log.Printf("Warning: Failed to resolve domain %s: %v", pfs.externalIP, err)
-		} else if len(ips) > 0 {
-			// Use first IPv4 address
-			for _, ip := range ips {
-				if ip4 := ip.To4(); ip4 != nil {
-					pfs.externalIP = ip4.String()
-					log.Printf("Resolved %s to %s", config.WgEndpoint, pfs.externalIP)
-					break
-				}
-			}
+	host := endpointHost(settings.Current().WgEndpoint)
+	pfs.externalIP = host
+	if net.ParseIP(host) == nil {
+		if ip, err := resolveIPv4(host); err != nil {
+			slog.Warn("Failed to resolve endpoint", "host", host, "error", err)
+		} else {
+			pfs.externalIP = ip
+			slog.Info("Resolved endpoint", "host", host, "external_ip", pfs.externalIP)
 		}
 	}
 
+	pfs.loadPortLeases()
+
 	// Start NAT-PMP server
 	if err := pfs.startNATPMPServer(); err != nil {
-		log.Printf("Failed to start NAT-PMP server: %v", err)
+		slog.Error("Failed to start NAT-PMP server", "error", err)
 		pfs.enabled = false
 		return pfs
 	}
 
-	log.Println("✓ Port forwarding server enabled")
-	log.Printf("  NAT-PMP server listening on %s:5351", config.WgAddressV4)
-	log.Println("  VPN clients can now request port forwards")
+	pfs.restoreMappings()
+
+	slog.Info("Port forwarding server enabled", "natpmp_addr", pfs.natpmpConn.LocalAddr().String())
+
+	// Start expiry scheduler
+	go pfs.runExpiryScheduler(pfs.ctx)
 
-	// Start cleanup goroutine
-	go pfs.cleanupExpiredMappings()
+	// Also picks up a wg_endpoint changed by a config reload
+	go pfs.watchExternalIP(pfs.ctx)
 
 	return pfs
 }
 
+// endpointHost returns the host part of wg_endpoint ("vpn.example.com:51820"
+// -> "vpn.example.com").
+func endpointHost(endpoint string) string {
+	if host, _, err := net.SplitHostPort(endpoint); err == nil {
+		return host
+	}
+	return endpoint
+}
+
+// resolveIPv4 returns the first IPv4 address of host.
+func resolveIPv4(host string) (string, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return "", err
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no IPv4 address")
+}
+
+// watchExternalIP periodically resolves wg_endpoint again until ctx is
+// cancelled.
+func (pfs *PortForwardServer) watchExternalIP(ctx context.Context) {
+	ticker := time.NewTicker(externalIPCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pfs.refreshExternalIP()
+		}
+	}
+}
+
+// refreshExternalIP sets the external IP from wg_endpoint, resolving a
+// hostname, and publishes a change. A failed lookup keeps the previous
+// address.
+func (pfs *PortForwardServer) refreshExternalIP() {
+	if !pfs.enabled {
+		return
+	}
+
+	host := endpointHost(pfs.settings.Current().WgEndpoint)
+	ip := host
+	if net.ParseIP(host) == nil {
+		resolved, err := resolveIPv4(host)
+		if err != nil {
+			slog.Warn("Failed to resolve endpoint", "host", host, "error", err)
+			return
+		}
+		ip = resolved
+	}
+
+	pfs.mu.Lock()
+	previous := pfs.externalIP
+	pfs.externalIP = ip
+	pfs.mu.Unlock()
+
+	if ip != previous {
+		slog.Info("External IP changed", "previous", previous, "external_ip", ip)
+		pfs.bus.Publish(Event{
+			Type: EventExternalIPChanged,
+			Data: map[string]string{"previous": previous, "external_ip": ip},
+		})
+	}
+}
+
+// ExternalIP returns the public IPv4 address announced to NAT-PMP clients.
+func (pfs *PortForwardServer) ExternalIP() string {
+	pfs.mu.RLock()
+	defer pfs.mu.RUnlock()
+	return pfs.externalIP
+}
+
 func (pfs *PortForwardServer) startNATPMPServer() error {
 	// NAT-PMP listens on port 5351
 	// Parse IP address from CIDR notation (e.g., "10.8.0.1/24" -> "10.8.0.1")
@@ -107,8 +226,12 @@ func (pfs *PortForwardServer) startNATPMPServer() error {
 
 	pfs.natpmpConn = conn
 
-	// Start handling requests
+	// Start handling requests; closing the socket unblocks the read loop
 	go pfs.handleNATPMPRequests()
+	go func() {
+		<-pfs.ctx.Done()
+		conn.Close()
+	}()
 
 	return nil
 }
@@ -119,7 +242,10 @@ func (pfs *PortForwardServer) handleNATPMPRequests() {
 	for {
 		n, clientAddr, err := pfs.natpmpConn.ReadFromUDP(buf)
 		if err != nil {
-			log.Printf("NAT-PMP read error: %v", err)
+			if pfs.ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("NAT-PMP read error", "error", err)
 			continue
 		}
 
@@ -131,6 +257,7 @@ func (pfs *PortForwardServer) handleNATPMPRequests() {
 		opcode := buf[1]
 
 		if version != 0 {
+			metrics.natpmpRequests.Inc(natpmpOpcodeName(opcode), "ignored")
 			continue // Only support version 0
 		}
 
@@ -140,15 +267,34 @@ func (pfs *PortForwardServer) handleNATPMPRequests() {
 		case 1: // UDP port mapping request
 			if n >= 12 {
 				pfs.handlePortMappingRequest(clientAddr, buf[:n], "udp")
+			} else {
+				metrics.natpmpRequests.Inc("map_udp", "ignored")
 			}
 		case 2: // TCP port mapping request
 			if n >= 12 {
 				pfs.handlePortMappingRequest(clientAddr, buf[:n], "tcp")
+			} else {
+				metrics.natpmpRequests.Inc("map_tcp", "ignored")
 			}
+		default:
+			metrics.natpmpRequests.Inc(natpmpOpcodeName(opcode), "ignored")
 		}
 	}
 }
 
+// natpmpOpcodeName is the opcode label of the NAT-PMP request metric.
+func natpmpOpcodeName(opcode byte) string {
+	switch opcode {
+	case 0:
+		return "public_address"
+	case 1:
+		return "map_udp"
+	case 2:
+		return "map_tcp"
+	}
+	return "unknown"
+}
+
 func (pfs *PortForwardServer) handlePublicAddressRequest(clientAddr *net.UDPAddr) {
 	response := make([]byte, 12)
 	response[0] = 0   // Version
@@ -161,7 +307,7 @@ func (pfs *PortForwardServer) handlePublicAddressRequest(clientAddr *net.UDPAddr
 	binary.BigEndian.PutUint32(response[4:8], uint32(time.Now().Unix()))
 
 	// External IP address
-	ip := net.ParseIP(pfs.externalIP)
+	ip := net.ParseIP(pfs.ExternalIP())
 	if ip == nil {
 		ip = net.ParseIP("0.0.0.0")
 	}
@@ -170,7 +316,8 @@ func (pfs *PortForwardServer) handlePublicAddressRequest(clientAddr *net.UDPAddr
 	}
 
 	pfs.natpmpConn.WriteToUDP(response, clientAddr)
-	log.Printf("NAT-PMP: Public address request from %s", clientAddr.IP)
+	slog.Debug("NAT-PMP public address request", "client_ip", clientAddr.IP.String(), "result_code", natpmpResultSuccess)
+	metrics.natpmpRequests.Inc("public_address", strconv.Itoa(natpmpResultSuccess))
 }
 
 func (pfs *PortForwardServer) handlePortMappingRequest(clientAddr *net.UDPAddr, data []byte, protocol string) {
@@ -184,32 +331,35 @@ func (pfs *PortForwardServer) handlePortMappingRequest(clientAddr *net.UDPAddr,
 
 	clientIP := clientAddr.IP.String()
 
-	var resultCode uint16 = 0 // Success
+	var resultCode uint16 = natpmpResultSuccess
 	var assignedPort uint16 = externalPort
 
 	if lifetime == 0 {
 		// Delete mapping
-		if err := pfs.removeMapping(clientIP, externalPort, protocol); err != nil {
-			log.Printf("NAT-PMP: Failed to remove mapping: %v", err)
-			resultCode = 3 // Network failure
+		if _, err := pfs.removeMapping(clientIP, externalPort, protocol, natpmpRemoveReason); err != nil {
+			resultCode = natpmpResultNetworkFailure
+			slog.Warn("NAT-PMP mapping removal failed", "client_ip", clientIP, "ext_port", externalPort,
+				"protocol", protocol, "result_code", resultCode, "error", err)
 		} else {
-			log.Printf("NAT-PMP: Removed %s port %d for %s", protocol, externalPort, clientIP)
+			slog.Info("NAT-PMP mapping removed", "client_ip", clientIP, "ext_port", externalPort,
+				"protocol", protocol, "result_code", resultCode)
 		}
 	} else {
-		// Add/renew mapping
-		if externalPort == 0 {
-			// Client wants us to assign a port
-			assignedPort = pfs.findAvailablePort(protocol)
-		}
-
-		err := pfs.addMapping(clientIP, assignedPort, internalPort, protocol, "NAT-PMP", lifetime)
+		// Add/renew mapping; the external port is only a suggestion
+		port, err := pfs.addMapping(clientIP, externalPort, internalPort, protocol, natpmpDescription, lifetime)
 		if err != nil {
-			log.Printf("NAT-PMP: Failed to add mapping: %v", err)
-			resultCode = 4 // Out of resources
+			if errors.Is(err, errPortReserved) {
+				resultCode = natpmpResultNotAuthorized
+			} else {
+				resultCode = natpmpResultOutOfResources
+			}
 			assignedPort = 0
+			slog.Warn("NAT-PMP mapping refused", "client_ip", clientIP, "ext_port", externalPort, "int_port", internalPort,
+				"protocol", protocol, "result_code", resultCode, "error", err)
 		} else {
-			log.Printf("NAT-PMP: Added %s port %d -> %s:%d (lifetime: %ds)",
-				protocol, assignedPort, clientIP, internalPort, lifetime)
+			assignedPort = port
+			slog.Info("NAT-PMP mapping added", "client_ip", clientIP, "ext_port", assignedPort, "int_port", internalPort,
+				"protocol", protocol, "lifetime", lifetime, "result_code", resultCode)
 		}
 	}
 
@@ -229,27 +379,55 @@ func (pfs *PortForwardServer) handlePortMappingRequest(clientAddr *net.UDPAddr,
 	binary.BigEndian.PutUint32(response[12:16], lifetime)
 
 	pfs.natpmpConn.WriteToUDP(response, clientAddr)
+	metrics.natpmpRequests.Inc("map_"+protocol, strconv.Itoa(int(resultCode)))
 }
 
-func (pfs *PortForwardServer) addMapping(clientIP string, externalPort, internalPort uint16, protocol, description string, lifetime uint32) error {
+func (pfs *PortForwardServer) addMapping(clientIP string, requestedPort, internalPort uint16, protocol, description string, lifetime uint32) (uint16, error) {
+	// Asking for a reserved port is refused outright rather than reassigned
+	if requestedPort != 0 {
+		if reason, reserved := pfs.reserved.Reason(requestedPort, protocol); reserved {
+			return 0, fmt.Errorf("%w: %s port %d (%s)", errPortReserved, protocol, requestedPort, reason)
+		}
+	}
+
+	// Deferred first so the events are recorded after the unlock
+	var events []MappingEvent
+	defer func() { pfs.events.Record(events...) }()
+
 	pfs.mu.Lock()
 	defer pfs.mu.Unlock()
 
-	// Validate port range
-	if externalPort < pfs.config.PortForwardMinPort || externalPort > pfs.config.PortForwardMaxPort {
-		return fmt.Errorf("port %d outside allowed range (%d-%d)",
-			externalPort, pfs.config.PortForwardMinPort, pfs.config.PortForwardMaxPort)
+	externalPort, err := pfs.allocatePort(clientIP, requestedPort, internalPort, protocol)
+	if err != nil {
+		return 0, err
 	}
 
-	// Check if port is already mapped to a different client
 	key := fmt.Sprintf("%s:%d:%s", clientIP, externalPort, protocol)
-	for existingKey, mapping := range pfs.mappings {
-		if existingKey != key && mapping.ExternalPort == externalPort && mapping.Protocol == protocol {
-			return fmt.Errorf("port %d already mapped to %s", externalPort, mapping.ClientIP)
+	now := time.Now()
+
+	if _, exists := pfs.mappings[key]; !exists {
+		if limit := pfs.settings.Current().PortForwardMaxPerClient; pfs.countMappings(clientIP) >= limit {
+			return 0, fmt.Errorf("%w: %s already has %d port forwards", errMappingLimit, clientIP, limit)
 		}
 	}
 
-	// Create or update mapping
+	if existing, exists := pfs.mappings[key]; exists {
+		// Renewal: the iptables rules are already in place. Replace rather
+		// than modify the mapping, readers may still hold the old one.
+		renewed := *existing
+		renewed.Lifetime = lifetime
+		renewed.ExpiresAt = now.Add(time.Duration(lifetime) * time.Second)
+		renewed.RenewalCount++
+		renewed.LastRenewed = now
+		pfs.mappings[key] = &renewed
+		pfs.scheduleExpiry(key, renewed.ExpiresAt)
+		pfs.saveMappings()
+		pfs.rememberPort(clientIP, externalPort, internalPort, protocol)
+		events = append(events, newMappingEvent(MappingRenewed, &renewed, ""))
+		return externalPort, nil
+	}
+
+	// Create mapping
 	mapping := &PortMapping{
 		ClientIP:     clientIP,
 		ExternalPort: externalPort,
@@ -257,57 +435,62 @@ func (pfs *PortForwardServer) addMapping(clientIP string, externalPort, internal
 		Protocol:     protocol,
 		Description:  description,
 		Lifetime:     lifetime,
-		CreatedAt:    time.Now(),
-		ExpiresAt:    time.Now().Add(time.Duration(lifetime) * time.Second),
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(time.Duration(lifetime) * time.Second),
 	}
 
 	pfs.mappings[key] = mapping
 
-	// Add iptables rule
+	// Add iptables rule. Unlike removals this stays under the lock, so a
+	// concurrent remove can never run before the rules exist.
 	if err := pfs.addIPTablesRule(clientIP, externalPort, internalPort, protocol); err != nil {
 		delete(pfs.mappings, key)
-		return fmt.Errorf("failed to add iptables rule: %v", err)
+		return 0, fmt.Errorf("failed to add iptables rule: %v", err)
 	}
 
-	return nil
+	pfs.scheduleExpiry(key, mapping.ExpiresAt)
+	pfs.saveMappings()
+	pfs.rememberPort(clientIP, externalPort, internalPort, protocol)
+	events = append(events, newMappingEvent(MappingCreated, mapping, ""))
+	return externalPort, nil
 }
 
-func (pfs *PortForwardServer) removeMapping(clientIP string, externalPort uint16, protocol string) error {
-	pfs.mu.Lock()
-	defer pfs.mu.Unlock()
+// countMappings returns the number of mappings of a client. Must be called
+// with pfs.mu held.
+func (pfs *PortForwardServer) countMappings(clientIP string) int {
+	count := 0
+	for _, mapping := range pfs.mappings {
+		if mapping.ClientIP == clientIP {
+			count++
+		}
+	}
+	return count
+}
 
+// removeMapping deletes a mapping and returns it; reason is recorded with
+// the removal event.
+func (pfs *PortForwardServer) removeMapping(clientIP string, externalPort uint16, protocol, reason string) (*PortMapping, error) {
 	key := fmt.Sprintf("%s:%d:%s", clientIP, externalPort, protocol)
+
+	pfs.mu.Lock()
 	mapping, exists := pfs.mappings[key]
 	if !exists {
-		return fmt.Errorf("mapping not found")
+		pfs.mu.Unlock()
+		return nil, fmt.Errorf("mapping not found")
 	}
+	delete(pfs.mappings, key)
+	pfs.saveMappings()
+	event := newMappingEvent(MappingRemoved, mapping, reason)
+	pfs.mu.Unlock()
+
+	pfs.events.Record(event)
 
 	// Remove iptables rule
 	if err := pfs.removeIPTablesRule(clientIP, externalPort, mapping.InternalPort, protocol); err != nil {
-		log.Printf("Warning: Failed to remove iptables rule: %v", err)
+		slog.Warn("Failed to remove iptables rule", "client_ip", clientIP, "ext_port", externalPort, "protocol", protocol, "error", err)
 	}
 
-	delete(pfs.mappings, key)
-	return nil
-}
-
-func (pfs *PortForwardServer) findAvailablePort(protocol string) uint16 {
-	pfs.mu.RLock()
-	defer pfs.mu.RUnlock()
-
-	for port := pfs.config.PortForwardMinPort; port <= pfs.config.PortForwardMaxPort; port++ {
-		available := true
-		for _, mapping := range pfs.mappings {
-			if mapping.ExternalPort == port && mapping.Protocol == protocol {
-				available = false
-				break
-			}
-		}
-		if available {
-			return port
-		}
-	}
-	return 0
+	return mapping, nil
 }
 
 func (pfs *PortForwardServer) addIPTablesRule(clientIP string, externalPort, internalPort uint16, protocol string) error {
@@ -332,17 +515,15 @@ func (pfs *PortForwardServer) addIPTablesRule(clientIP string, externalPort, int
 		"-j", "ACCEPT",
 	}
 
-	log.Printf("Adding iptables rules for %s:%d -> %s:%d", protocol, externalPort, clientIP, internalPort)
+	slog.Debug("Adding iptables rules", "client_ip", clientIP, "ext_port", externalPort, "int_port", internalPort, "protocol", protocol)
 
 	// Execute DNAT rule
-	cmd := exec.Command("iptables", dnatArgs...)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runIPTables("add", dnatArgs...); err != nil {
 		return fmt.Errorf("failed to add DNAT rule: %v - %s", err, string(output))
 	}
 
 	// Execute FORWARD rule
-	cmd = exec.Command("iptables", forwardArgs...)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runIPTables("add", forwardArgs...); err != nil {
 		// Try to remove the DNAT rule we just added
 		pfs.removeIPTablesRule(clientIP, externalPort, internalPort, protocol)
 		return fmt.Errorf("failed to add FORWARD rule: %v - %s", err, string(output))
@@ -351,8 +532,32 @@ func (pfs *PortForwardServer) addIPTablesRule(clientIP string, externalPort, int
 	return nil
 }
 
+// runIPTables runs iptables and records its duration and failures under the
+// given operation label.
+func runIPTables(operation string, args ...string) ([]byte, error) {
+	start := time.Now()
+	output, err := exec.Command("iptables", args...).CombinedOutput()
+	metrics.firewallDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		metrics.firewallFailures.Inc(operation)
+	}
+	return output, err
+}
+
+// hasIPTablesRule reports whether the DNAT rule of a mapping exists.
+func (pfs *PortForwardServer) hasIPTablesRule(clientIP string, externalPort, internalPort uint16, protocol string) bool {
+	cmd := exec.Command("iptables",
+		"-t", "nat",
+		"-C", "PREROUTING",
+		"-p", protocol,
+		"--dport", fmt.Sprintf("%d", externalPort),
+		"-j", "DNAT",
+		"--to-destination", fmt.Sprintf("%s:%d", clientIP, internalPort))
+	return cmd.Run() == nil
+}
+
 func (pfs *PortForwardServer) removeIPTablesRule(clientIP string, externalPort, internalPort uint16, protocol string) error {
-	log.Printf("Removing iptables rules for %s:%d -> %s:%d", protocol, externalPort, clientIP, internalPort)
+	slog.Debug("Removing iptables rules", "client_ip", clientIP, "ext_port", externalPort, "int_port", internalPort, "protocol", protocol)
 
 	// Remove DNAT rule
 	dnatArgs := []string{
@@ -364,9 +569,9 @@ func (pfs *PortForwardServer) removeIPTablesRule(clientIP string, externalPort,
 		"--to-destination", fmt.Sprintf("%s:%d", clientIP, internalPort),
 	}
 
-	cmd := exec.Command("iptables", dnatArgs...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("Warning: Failed to remove DNAT rule: %v - %s", err, string(output))
+	if output, err := runIPTables("remove", dnatArgs...); err != nil {
+		slog.Warn("Failed to remove DNAT rule", "client_ip", clientIP, "ext_port", externalPort, "protocol", protocol,
+			"error", err, "output", strings.TrimSpace(string(output)))
 	}
 
 	// Remove FORWARD rule
@@ -378,33 +583,14 @@ func (pfs *PortForwardServer) removeIPTablesRule(clientIP string, externalPort,
 		"-j", "ACCEPT",
 	}
 
-	cmd = exec.Command("iptables", forwardArgs...)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("Warning: Failed to remove FORWARD rule: %v - %s", err, string(output))
+	if output, err := runIPTables("remove", forwardArgs...); err != nil {
+		slog.Warn("Failed to remove FORWARD rule", "client_ip", clientIP, "int_port", internalPort, "protocol", protocol,
+			"error", err, "output", strings.TrimSpace(string(output)))
 	}
 
 	return nil
 }
 
-func (pfs *PortForwardServer) cleanupExpiredMappings() {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		pfs.mu.Lock()
-		now := time.Now()
-		for key, mapping := range pfs.mappings {
-			if now.After(mapping.ExpiresAt) {
-				log.Printf("Cleaning up expired mapping: %s:%d (%s)",
-					mapping.ClientIP, mapping.ExternalPort, mapping.Protocol)
-				pfs.removeIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol)
-				delete(pfs.mappings, key)
-			}
-		}
-		pfs.mu.Unlock()
-	}
-}
-
 func (pfs *PortForwardServer) GetAllMappings() []*PortMapping {
 	pfs.mu.RLock()
 	defer pfs.mu.RUnlock()
@@ -429,27 +615,44 @@ func (pfs *PortForwardServer) GetClientMappings(clientIP string) []*PortMapping
 	return result
 }
 
-func (pfs *PortForwardServer) RemoveAllClientMappings(clientIP string) error {
-	pfs.mu.Lock()
-	defer pfs.mu.Unlock()
+func (pfs *PortForwardServer) RemoveAllClientMappings(clientIP, reason string) error {
+	var removed []*PortMapping
+	var events []MappingEvent
 
+	pfs.mu.Lock()
 	for key, mapping := range pfs.mappings {
 		if mapping.ClientIP == clientIP {
-			pfs.removeIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol)
 			delete(pfs.mappings, key)
+			events = append(events, newMappingEvent(MappingRemoved, mapping, reason))
+			removed = append(removed, mapping)
 		}
 	}
+	if len(removed) > 0 {
+		pfs.saveMappings()
+	}
+	pfs.mu.Unlock()
+
+	pfs.events.Record(events...)
+
+	for _, mapping := range removed {
+		pfs.removeIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol)
+	}
 
 	return nil
 }
 
+// Cleanup stops the NAT-PMP server and removes all firewall rules, unless
+// shutdown_keep_port_forwards is set. In that case the rules stay in place
+// and the persisted mappings are adopted again on the next start.
 func (pfs *PortForwardServer) Cleanup() {
 	if !pfs.enabled {
 		return
 	}
 
-	log.Println("Cleaning up port forward server...")
+	slog.Info("Cleaning up port forward server")
 
+	// Stop the expiry scheduler and the NAT-PMP listener
+	pfs.cancel()
 	if pfs.natpmpConn != nil {
 		pfs.natpmpConn.Close()
 	}
@@ -457,12 +660,101 @@ func (pfs *PortForwardServer) Cleanup() {
 	pfs.mu.Lock()
 	defer pfs.mu.Unlock()
 
+	pfs.savePortLeases()
+
+	if pfs.config.ShutdownKeepPortForwards {
+		pfs.saveMappings()
+		slog.Info("Keeping port forwards in place", "count", len(pfs.mappings))
+		return
+	}
+
 	for _, mapping := range pfs.mappings {
 		pfs.removeIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol)
 	}
 	pfs.mappings = make(map[string]*PortMapping)
+	pfs.expiry = nil
+	pfs.saveMappings()
+
+	slog.Info("Port forward server cleanup complete")
+}
+
+// saveMappings persists the active mappings so their firewall rules can be
+// adopted or removed after a restart or crash. Must be called with pfs.mu held.
+func (pfs *PortForwardServer) saveMappings() {
+	mappings := make([]*PortMapping, 0, len(pfs.mappings))
+	for _, mapping := range pfs.mappings {
+		mappings = append(mappings, mapping)
+	}
+	if err := saveState(pfs.config, portMappingsFile, mappings); err != nil {
+		slog.Warn("Failed to save port mappings", "error", err)
+	}
+}
 
-	log.Println("Port forward server cleanup complete")
+// restoreMappings adopts the mappings of a previous run. Rules of expired
+// mappings are removed; live mappings get their rules re-added if the
+// firewall lost them, e.g. after a reboot.
+func (pfs *PortForwardServer) restoreMappings() {
+	var mappings []*PortMapping
+	if err := loadState(pfs.config, portMappingsFile, &mappings); err != nil {
+		slog.Warn("Failed to load port mappings", "error", err)
+		return
+	}
+
+	pfs.mu.Lock()
+	defer pfs.mu.Unlock()
+
+	now := time.Now()
+	for _, mapping := range mappings {
+		if !mapping.ExpiresAt.After(now) {
+			pfs.removeIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol)
+			continue
+		}
+
+		if !pfs.hasIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol) {
+			if err := pfs.addIPTablesRule(mapping.ClientIP, mapping.ExternalPort, mapping.InternalPort, mapping.Protocol); err != nil {
+				slog.Warn("Failed to restore port forward", "client_ip", mapping.ClientIP, "ext_port", mapping.ExternalPort,
+					"protocol", mapping.Protocol, "error", err)
+				continue
+			}
+		}
+
+		key := fmt.Sprintf("%s:%d:%s", mapping.ClientIP, mapping.ExternalPort, mapping.Protocol)
+		pfs.mappings[key] = mapping
+		pfs.scheduleExpiry(key, mapping.ExpiresAt)
+	}
+
+	pfs.saveMappings()
+	if len(pfs.mappings) > 0 {
+		slog.Info("Restored port forwards", "count", len(pfs.mappings))
+	}
+}
+
+// GetEvents returns recent mapping events, newest first. An empty clientIP
+// returns events for all clients.
+func (pfs *PortForwardServer) GetEvents(clientIP string, limit int) []MappingEvent {
+	return pfs.events.Recent(clientIP, limit)
+}
+
+// NATPMPAddr returns the address the NAT-PMP server listens on, or an error
+// if it is not listening.
+func (pfs *PortForwardServer) NATPMPAddr() (string, error) {
+	if pfs.natpmpConn == nil {
+		return "", fmt.Errorf("NAT-PMP server failed to start")
+	}
+	if pfs.ctx.Err() != nil {
+		return "", fmt.Errorf("NAT-PMP server stopped")
+	}
+	return pfs.natpmpConn.LocalAddr().String(), nil
+}
+
+// CheckFirewall reports whether iptables can be run with the privileges
+// port forwarding needs, by listing the nat table's PREROUTING chain.
+func (pfs *PortForwardServer) CheckFirewall(ctx context.Context) error {
+	output, err := exec.CommandContext(ctx, "iptables", "-t", "nat", "-S", "PREROUTING").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("iptables failed: %v - %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
 }
 
 func (pfs *PortForwardServer) IsEnabled() bool {
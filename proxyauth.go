@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxies parses trusted_proxies entries, which are CIDRs or
+// single addresses.
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted_proxies entry %q", entry)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted_proxies entry %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// fromTrustedProxy reports whether the request's direct peer is one of the
+// configured trusted proxies. Only then are X-Forwarded-* and the proxy auth
+// header believed.
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	addr, err := netip.ParseAddr(remoteHost(r))
+	return err == nil && s.isTrustedProxy(addr)
+}
+
+func (s *Server) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the address of the client. Behind trusted proxies the
+// X-Forwarded-For chain is walked from the right, skipping trusted proxies,
+// so clients cannot pick their address by sending the header themselves.
+func (s *Server) clientIP(r *http.Request) string {
+	peer := remoteHost(r)
+	if !s.fromTrustedProxy(r) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+			hops = []string{realIP}
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !s.isTrustedProxy(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// basePath returns the path prefix the browser sees: X-Forwarded-Prefix from
+// a trusted proxy, otherwise base_path.
+func (s *Server) basePath(r *http.Request) string {
+	if prefix := r.Header.Get("X-Forwarded-Prefix"); prefix != "" && s.fromTrustedProxy(r) {
+		if prefix = strings.Trim(prefix, "/"); prefix == "" {
+			return ""
+		}
+		return "/" + prefix
+	}
+	return s.config.BasePath
+}
+
+// externalURL returns the absolute URL of path as seen by the browser.
+func (s *Server) externalURL(r *http.Request, path string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" && s.fromTrustedProxy(r) {
+		scheme = strings.ToLower(strings.TrimSpace(strings.Split(proto, ",")[0]))
+	}
+	return scheme + "://" + r.Host + s.basePath(r) + path
+}
+
+// redirect sends a 303 to path below the external base path.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, path string) {
+	http.Redirect(w, r, s.basePath(r)+path, http.StatusSeeOther)
+}
+
+// proxyUser returns the user authenticated by the reverse proxy, or nil when
+// proxy authentication is off, the header is missing, or the request did not
+// come through a trusted proxy.
+func (s *Server) proxyUser(r *http.Request) *User {
+	if s.config.ProxyAuthHeader == "" {
+		return nil
+	}
+	username := strings.TrimSpace(r.Header.Get(s.config.ProxyAuthHeader))
+	if username == "" {
+		return nil
+	}
+	if !s.fromTrustedProxy(r) {
+		requestLogger(r).Warn("Ignoring proxy auth header from untrusted address", "header", s.config.ProxyAuthHeader, "remote_addr", r.RemoteAddr)
+		return nil
+	}
+
+	var groups []string
+	for _, group := range strings.Split(r.Header.Get(s.config.ProxyAuthGroupsHeader), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	role := mapRole(groups, s.config.ProxyAuthRoleMapping, s.config.ProxyAuthDefaultRole)
+	if role == "" {
+		requestLogger(r).Warn("Proxy login refused, no role mapped", "user", username, "groups", groups)
+		return nil
+	}
+	return &User{Username: proxyUserPrefix + username, Role: role, External: true}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+// Hot reload: SIGHUP or the Configuration page re-reads the config file and
+// environment, applies the keys below to the running server and reports
+// every other change as needing a restart.
+
+// reloadableKeys are the config keys applied without a restart.
+var reloadableKeys = map[string]bool{
+	"port_forward_min_port":       true,
+	"port_forward_max_port":       true,
+	"port_forward_max_per_client": true,
+	"port_forward_lifetime":       true,
+	"port_forward_allocation":     true,
+	"user_client_quota":           true,
+	"wg_dns":                      true,
+	"wg_allowed_ips":              true,
+	"wg_mtu":                      true,
+	"wg_persistent_keepalive":     true,
+	"wg_endpoint":                 true,
+	"log_level":                   true,
+}
+
+// liveConfigMu guards the reloadable fields of the running config. Code
+// reading them goes through Snapshot; all other fields never change after
+// startup and are read directly.
+var liveConfigMu sync.RWMutex
+
+// Snapshot returns a copy of the config that a concurrent reload cannot
+// change.
+func (c *Config) Snapshot() Config {
+	liveConfigMu.RLock()
+	defer liveConfigMu.RUnlock()
+	return *c
+}
+
+// ReloadResult lists the config keys that changed, by whether they took
+// effect. Overridden keys were applied but a saved setting takes precedence.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	Overridden      []string `json:"overridden"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+type ConfigReloader struct {
+	path     string // "" if configured by the environment only
+	config   *Config
+	settings *SettingsStore
+	pf       *PortForwardServer
+	auditLog *AuditLog
+	mu       sync.Mutex // one reload at a time
+}
+
+func NewConfigReloader(path string, config *Config, settings *SettingsStore, pf *PortForwardServer, auditLog *AuditLog) *ConfigReloader {
+	return &ConfigReloader{path: path, config: config, settings: settings, pf: pf, auditLog: auditLog}
+}
+
+// Run reloads the config on SIGHUP until ctx is cancelled.
+func (cr *ConfigReloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading configuration")
+			if result, err := cr.Reload(); err == nil {
+				cr.auditLog.Record(AuditEntry{Actor: AuditActorSystem, Action: "config.reload", Details: "SIGHUP", After: auditJSON(result)})
+			}
+		}
+	}
+}
+
+// Reload loads the config again and applies the reloadable changes. An
+// invalid config is rejected as a whole and nothing changes.
+func (cr *ConfigReloader) Reload() (*ReloadResult, error) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	next, err := LoadConfig(cr.path)
+	if err != nil {
+		slog.Error("Configuration reload failed, keeping the running configuration", "error", err)
+		return nil, err
+	}
+	if problems := cr.settings.Check(next); len(problems) > 0 {
+		err := ConfigError(problems)
+		slog.Error("Configuration reload conflicts with the saved settings, keeping the running configuration", "error", err)
+		return nil, fmt.Errorf("with the saved settings applied, %v", err)
+	}
+
+	result := &ReloadResult{Applied: []string{}, Overridden: []string{}, RestartRequired: []string{}}
+	overridden := cr.settings.Overridden()
+	current := reflect.ValueOf(cr.config).Elem()
+	updated := reflect.ValueOf(next).Elem()
+	keys := configKeys()
+
+	liveConfigMu.Lock()
+	for _, key := range sortedKeys(keys) {
+		index := keys[key]
+		if reflect.DeepEqual(current.Field(index).Interface(), updated.Field(index).Interface()) {
+			continue
+		}
+		if !reloadableKeys[key] {
+			result.RestartRequired = append(result.RestartRequired, key)
+			continue
+		}
+		current.Field(index).Set(updated.Field(index))
+		result.Applied = append(result.Applied, key)
+		if slices.Contains(overridden, key) {
+			result.Overridden = append(result.Overridden, key)
+		}
+	}
+	liveConfigMu.Unlock()
+
+	for _, key := range result.Applied {
+		switch key {
+		case "log_level":
+			level, _ := parseLogLevel(next.LogLevel) // validated by LoadConfig
+			logLevel.Set(level)
+		case "wg_endpoint":
+			cr.pf.refreshExternalIP()
+		}
+	}
+
+	if len(result.RestartRequired) > 0 {
+		slog.Warn("Configuration reloaded, some changes need a restart",
+			"applied", result.Applied, "overridden", result.Overridden, "restart_required", result.RestartRequired)
+	} else {
+		slog.Info("Configuration reloaded", "applied", result.Applied, "overridden", result.Overridden)
+	}
+	return result, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errPortReserved is returned when a client asks for a reserved external port.
+var errPortReserved = errors.New("port is reserved")
+
+const hostPortsRefresh = 10 * time.Second
+
+type reservedPort struct {
+	Port     uint16
+	Protocol string
+}
+
+// ReservedPorts is the registry of external ports that must never be
+// forwarded: the web UI, WireGuard, NAT-PMP itself, anything configured in
+// port_forward_reserved_ports and every socket listening on the host.
+type ReservedPorts struct {
+	static map[reservedPort]string // value: reason
+
+	mu        sync.Mutex
+	hostPorts map[reservedPort]bool
+	scannedAt time.Time
+}
+
+func NewReservedPorts(config *Config) *ReservedPorts {
+	rp := &ReservedPorts{
+		static: make(map[reservedPort]string),
+	}
+
+	if _, port, err := net.SplitHostPort(config.ListenAddr); err == nil {
+		if p, err := strconv.ParseUint(port, 10, 16); err == nil {
+			rp.static[reservedPort{uint16(p), "tcp"}] = "web UI"
+		}
+	}
+	if config.WgPort > 0 && config.WgPort <= 65535 {
+		rp.static[reservedPort{uint16(config.WgPort), "udp"}] = "WireGuard"
+	}
+	rp.static[reservedPort{5351, "udp"}] = "NAT-PMP"
+
+	// Entries were validated by LoadConfig
+	for _, spec := range config.PortForwardReservedPorts {
+		low, high, protocols, err := parsePortSpec(spec)
+		if err != nil {
+			slog.Warn("Ignoring reserved port", "spec", spec, "error", err)
+			continue
+		}
+		for port := int(low); port <= int(high); port++ {
+			for _, protocol := range protocols {
+				rp.static[reservedPort{uint16(port), protocol}] = "config"
+			}
+		}
+	}
+
+	return rp
+}
+
+// parsePortSpec parses "port", "low-high", optionally followed by "/tcp" or
+// "/udp". Without a protocol suffix both protocols are reserved.
+func parsePortSpec(spec string) (uint16, uint16, []string, error) {
+	protocols := []string{"tcp", "udp"}
+	ports := strings.TrimSpace(spec)
+	if i := strings.Index(ports, "/"); i >= 0 {
+		protocol := strings.ToLower(ports[i+1:])
+		if protocol != "tcp" && protocol != "udp" {
+			return 0, 0, nil, fmt.Errorf("unknown protocol %q", protocol)
+		}
+		protocols = []string{protocol}
+		ports = ports[:i]
+	}
+
+	lowStr, highStr, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		highStr = lowStr
+	}
+
+	low, err := strconv.ParseUint(lowStr, 10, 16)
+	if err != nil || low == 0 {
+		return 0, 0, nil, fmt.Errorf("invalid port %q", lowStr)
+	}
+	high, err := strconv.ParseUint(highStr, 10, 16)
+	if err != nil || high == 0 {
+		return 0, 0, nil, fmt.Errorf("invalid port %q", highStr)
+	}
+	if low > high {
+		return 0, 0, nil, fmt.Errorf("invalid range %s", ports)
+	}
+
+	return uint16(low), uint16(high), protocols, nil
+}
+
+// IsReserved reports whether the external port may not be forwarded.
+func (rp *ReservedPorts) IsReserved(port uint16, protocol string) bool {
+	_, reserved := rp.Reason(port, protocol)
+	return reserved
+}
+
+// Reason returns why the external port is reserved, if it is.
+func (rp *ReservedPorts) Reason(port uint16, protocol string) (string, bool) {
+	key := reservedPort{port, protocol}
+	if reason, ok := rp.static[key]; ok {
+		return reason, true
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	if time.Since(rp.scannedAt) > hostPortsRefresh {
+		rp.hostPorts = scanHostPorts()
+		rp.scannedAt = time.Now()
+	}
+	if rp.hostPorts[key] {
+		return "host service", true
+	}
+	return "", false
+}
+
+// scanHostPorts collects the ports of all listening TCP sockets and all
+// unconnected UDP sockets on the host.
+func scanHostPorts() map[reservedPort]bool {
+	ports := make(map[reservedPort]bool)
+	for _, source := range []struct {
+		path, protocol, state string
+	}{
+		{"/proc/net/tcp", "tcp", "0A"}, // TCP_LISTEN
+		{"/proc/net/tcp6", "tcp", "0A"},
+		{"/proc/net/udp", "udp", "07"}, // TCP_CLOSE, i.e. bound but unconnected
+		{"/proc/net/udp6", "udp", "07"},
+	} {
+		if err := readProcNet(source.path, source.protocol, source.state, ports); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to read reserved ports", "path", source.path, "error", err)
+		}
+	}
+	return ports
+}
+
+func readProcNet(path, protocol, state string, ports map[reservedPort]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Skip header
+	for scanner.Scan() {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != state {
+			continue
+		}
+		if !strings.HasSuffix(fields[2], ":0000") {
+			continue // Connected socket
+		}
+
+		i := strings.LastIndex(fields[1], ":")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+		if err != nil || port == 0 {
+			continue
+		}
+		ports[reservedPort{uint16(port), protocol}] = true
+	}
+	return scanner.Err()
+}
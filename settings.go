@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reflect"
+	"sync"
+)
+
+// Server settings: the values edited on the Configuration page, saved in
+// settings.json. Each one overrides the config key of the same name and
+// takes effect without a restart; removing it falls back to the config.
+
+const settingsFile = "settings.json"
+
+// ServerSettings are the editable settings, named by the config keys they
+// override. Field types match the Config fields.
+type ServerSettings struct {
+	WgEndpoint              string   `json:"wg_endpoint"`
+	WgDNS                   []string `json:"wg_dns"`
+	WgAllowedIPs            []string `json:"wg_allowed_ips"`
+	WgMTU                   int      `json:"wg_mtu"`
+	WgPersistentKeepalive   int      `json:"wg_persistent_keepalive"`
+	PortForwardMinPort      uint16   `json:"port_forward_min_port"`
+	PortForwardMaxPort      uint16   `json:"port_forward_max_port"`
+	PortForwardMaxPerClient int      `json:"port_forward_max_per_client"`
+	PortForwardLifetime     int      `json:"port_forward_lifetime"`
+	PortForwardAllocation   string   `json:"port_forward_allocation"`
+}
+
+// settingsKeys maps each setting to the index of its ServerSettings field.
+func settingsKeys() map[string]int {
+	return jsonKeys(reflect.TypeOf(ServerSettings{}))
+}
+
+// settingsOf returns the settings part of config.
+func settingsOf(config Config) ServerSettings {
+	var settings ServerSettings
+	v := reflect.ValueOf(&settings).Elem()
+	c := reflect.ValueOf(config)
+	keys := configKeys()
+	for key, index := range settingsKeys() {
+		v.Field(index).Set(c.Field(keys[key]))
+	}
+	return settings
+}
+
+type SettingsStore struct {
+	config    *Config
+	overrides map[string]json.RawMessage // setting -> JSON value, guarded by mu
+	mu        sync.RWMutex
+}
+
+func NewSettingsStore(config *Config) *SettingsStore {
+	ss := &SettingsStore{config: config, overrides: make(map[string]json.RawMessage)}
+
+	var overrides map[string]json.RawMessage
+	if err := loadState(config, settingsFile, &overrides); err != nil {
+		slog.Warn("Failed to load settings, using the config values", "error", err)
+		return ss
+	}
+	if _, problems := applySettings(config.Snapshot(), overrides); len(problems) > 0 {
+		slog.Warn("Ignoring invalid saved settings, using the config values", "problems", problems)
+		return ss
+	}
+	if len(overrides) > 0 {
+		ss.overrides = overrides
+		slog.Info("Loaded settings", "overridden", sortedKeys(overrides))
+	}
+	return ss
+}
+
+// applySettings returns config with overrides applied and validated.
+func applySettings(config Config, overrides map[string]json.RawMessage) (Config, []string) {
+	var problems []string
+	keys := settingsKeys()
+	known := make(map[string]json.RawMessage, len(overrides))
+	for _, key := range sortedKeys(overrides) {
+		if _, ok := keys[key]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown setting %q", key))
+			continue
+		}
+		known[key] = overrides[key]
+	}
+	problems = append(problems, decodeConfigValues(known, &config, settingsFile)...)
+	problems = append(problems, config.check()...)
+	return config, problems
+}
+
+// Current returns the running config with the settings applied. Code reading
+// settings or other reloadable values goes through Current.
+func (ss *SettingsStore) Current() Config {
+	config := ss.config.Snapshot()
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	decodeConfigValues(ss.overrides, &config, settingsFile) // validated when set
+	return config
+}
+
+// Overridden returns the settings that override the config, sorted.
+func (ss *SettingsStore) Overridden() []string {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return sortedKeys(ss.overrides)
+}
+
+// Check returns the problems of config with the settings applied, so that a
+// config reload conflicting with them is rejected.
+func (ss *SettingsStore) Check(config *Config) []string {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	_, problems := applySettings(*config, ss.overrides)
+	return problems
+}
+
+// Update sets the given settings; a null value removes the override. The
+// result is validated as a whole and nothing changes if it is invalid.
+func (ss *SettingsStore) Update(changes map[string]json.RawMessage) (before, after ServerSettings, err error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	overrides := maps.Clone(ss.overrides)
+	for key, value := range changes {
+		if value = bytes.TrimSpace(value); len(value) == 0 || string(value) == "null" {
+			delete(overrides, key)
+		} else {
+			overrides[key] = value
+		}
+	}
+
+	base := ss.config.Snapshot()
+	next, problems := applySettings(base, overrides)
+	if len(problems) > 0 {
+		return before, after, ConfigError(problems)
+	}
+
+	// Save the values with defaults filled in, e.g. 1024 for a zero
+	// port_forward_min_port
+	v := reflect.ValueOf(next)
+	keys := configKeys()
+	for key := range overrides {
+		if overrides[key], err = json.Marshal(v.Field(keys[key]).Interface()); err != nil {
+			return before, after, err
+		}
+	}
+	if err := saveState(ss.config, settingsFile, overrides); err != nil {
+		return before, after, fmt.Errorf("failed to save settings: %v", err)
+	}
+
+	current, _ := applySettings(base, ss.overrides)
+	ss.overrides = overrides
+	return settingsOf(current), settingsOf(next), nil
+}
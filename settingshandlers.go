@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Server settings, edited on the Configuration page or through the API
+
+// settingFields are the settings form fields of the Configuration page, in
+// order.
+var settingFields = []struct {
+	Key, Label, Hint string
+	Options          []string
+}{
+	{Key: "wg_endpoint", Label: "Endpoint", Hint: "host:port in client configs, also the NAT-PMP external address"},
+	{Key: "wg_dns", Label: "DNS servers", Hint: "comma-separated IP addresses"},
+	{Key: "wg_allowed_ips", Label: "Default AllowedIPs", Hint: "comma-separated networks, for clients without their own list"},
+	{Key: "wg_mtu", Label: "MTU", Hint: "0 leaves it to wg-quick"},
+	{Key: "wg_persistent_keepalive", Label: "Persistent keepalive", Hint: "seconds, -1 disables"},
+	{Key: "port_forward_min_port", Label: "Lowest forwarded port"},
+	{Key: "port_forward_max_port", Label: "Highest forwarded port"},
+	{Key: "port_forward_max_per_client", Label: "Port forwards per client"},
+	{Key: "port_forward_lifetime", Label: "Port forward lifetime", Hint: "seconds, default for the API"},
+	{Key: "port_forward_allocation", Label: "Port allocation", Options: []string{AllocationPreserve, AllocationRandom, AllocationSequential}},
+}
+
+type settingRow struct {
+	Key, Label, Hint string
+	Options          []string
+	Value            string // the override, "" if the config value applies
+	ConfigValue      string
+}
+
+// settingsResponse is the body of GET and PATCH /api/settings.
+type settingsResponse struct {
+	Settings   ServerSettings `json:"settings"`   // in effect
+	Config     ServerSettings `json:"config"`     // from the config file and environment
+	Overridden []string       `json:"overridden"` // saved settings overriding config
+}
+
+func formatSetting(v interface{}) string {
+	if list, ok := v.([]string); ok {
+		return strings.Join(list, ", ")
+	}
+	return fmt.Sprint(v)
+}
+
+func (s *Server) handleUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	// An empty field removes the override
+	changes := make(map[string]json.RawMessage)
+	var problems ConfigError
+	var settings ServerSettings
+	v := reflect.ValueOf(&settings).Elem()
+	keys := settingsKeys()
+	for _, field := range settingFields {
+		value := strings.TrimSpace(r.FormValue(field.Key))
+		if value == "" {
+			changes[field.Key] = nil
+			continue
+		}
+		target := v.Field(keys[field.Key])
+		if err := setConfigValue(target, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", field.Key, err))
+			continue
+		}
+		changes[field.Key], _ = json.Marshal(target.Interface())
+	}
+	if len(problems) > 0 {
+		s.renderConfig(w, r, nil, "Settings not saved.\n"+problems.Error(), "")
+		return
+	}
+
+	if err := s.updateSettings(r, changes); err != nil {
+		s.renderConfig(w, r, nil, "Settings not saved.\n"+err.Error(), "")
+		return
+	}
+	s.renderConfig(w, r, nil, "", "Settings saved.")
+}
+
+func (s *Server) handleAPISettings(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.settingsResponse())
+}
+
+func (s *Server) handleAPIUpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var changes map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	if err := s.updateSettings(r, changes); err != nil {
+		var configErr ConfigError
+		if errors.As(err, &configErr) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, s.settingsResponse())
+}
+
+func (s *Server) updateSettings(r *http.Request, changes map[string]json.RawMessage) error {
+	before, after, err := s.settings.Update(changes)
+	if err != nil {
+		return err
+	}
+	if before.WgEndpoint != after.WgEndpoint {
+		s.pf.refreshExternalIP()
+	}
+	requestLogger(r).Info("Settings updated", "user", currentUser(r).Username, "overridden", s.settings.Overridden())
+	s.audit(r, "settings.update", "", before, after)
+	return nil
+}
+
+func (s *Server) settingsResponse() settingsResponse {
+	return settingsResponse{
+		Settings:   settingsOf(s.settings.Current()),
+		Config:     settingsOf(s.config.Snapshot()),
+		Overridden: s.settings.Overridden(),
+	}
+}
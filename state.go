@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// loadState reads a JSON state file from the configured state directory.
+// A missing file leaves v untouched and is not an error.
+func loadState(config *Config, name string, v interface{}) error {
+	data, err := os.ReadFile(filepath.Join(config.StateDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// checkStateDir reports whether files can be created in the state directory.
+func checkStateDir(config *Config) error {
+	if err := os.MkdirAll(config.StateDir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(config.StateDir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// saveState atomically writes v as JSON into the configured state directory.
+func saveState(config *Config, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(config.StateDir, 0700); err != nil {
+		return err
+	}
+
+	path := filepath.Join(config.StateDir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// API token handlers
+
+// bearerToken returns the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[7:]), true
+}
+
+// isAPIRequest reports whether the request is for a JSON API route. These
+// accept API tokens and answer authentication errors with JSON.
+func (s *Server) isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, strings.TrimSuffix(s.config.BasePath, "/")+"/api/")
+}
+
+// tokenUser returns the owner of the token, limited to the token's scopes.
+func (s *Server) tokenUser(secret string) *User {
+	token, ok := s.tokens.Authenticate(secret)
+	if !ok {
+		return nil
+	}
+	owner, err := s.users.Get(token.Username)
+	if err != nil {
+		return nil
+	}
+	user := *owner
+	user.TokenPerms = token.Permissions()
+	return &user
+}
+
+// canManageTokens reports whether the request may list, create and revoke
+// tokens: only local accounts, and not with a token.
+func canManageTokens(user *User) bool {
+	return !user.External && user.TokenPerms == nil
+}
+
+func (s *Server) handleTokens(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !canManageTokens(user) {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderTokens(w, r, user, "", "")
+}
+
+func (s *Server) handleCreateToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !canManageTokens(user) {
+		http.NotFound(w, r)
+		return
+	}
+
+	r.ParseForm()
+	var scopes []TokenScope
+	for _, scope := range r.Form["scopes"] {
+		scopes = append(scopes, TokenScope(scope))
+	}
+	days, _ := strconv.Atoi(r.FormValue("expires_in_days"))
+
+	token, secret, err := s.tokens.Create(user.Username, r.FormValue("name"), scopes, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		s.renderTokens(w, r, user, "", err.Error())
+		return
+	}
+	requestLogger(r).Info("API token created", "user", user.Username, "token_id", token.ID, "token_name", token.Name, "scopes", token.Scopes)
+	s.audit(r, "token.create", token.ID, nil, auditToken(token))
+
+	s.renderTokens(w, r, user, secret, "")
+}
+
+func (s *Server) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !canManageTokens(user) {
+		http.NotFound(w, r)
+		return
+	}
+
+	token, err := s.tokens.Revoke(mux.Vars(r)["id"], s.tokenOwnerFilter(user))
+	if err != nil {
+		s.renderTokens(w, r, user, "", err.Error())
+		return
+	}
+	requestLogger(r).Info("API token revoked", "user", user.Username, "token_id", token.ID, "token_name", token.Name, "target_user", token.Username)
+	s.audit(r, "token.revoke", token.ID, auditToken(token), nil)
+
+	s.redirect(w, r, "/account/tokens")
+}
+
+// tokenOwnerFilter returns the username whose tokens the user may see and
+// revoke, or "" for administrators, who manage all tokens.
+func (s *Server) tokenOwnerFilter(user *User) string {
+	if user.Can(PermManageUsers) {
+		return ""
+	}
+	return user.Username
+}
+
+type tokenRequest struct {
+	Name          string       `json:"name"`
+	Scopes        []TokenScope `json:"scopes"`
+	ExpiresInDays int          `json:"expires_in_days,omitempty"`
+}
+
+// createdToken is the response to creating a token; the secret is not
+// retrievable later.
+type createdToken struct {
+	Token  APIToken `json:"token"`
+	Secret string   `json:"secret"`
+}
+
+func (s *Server) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !canManageTokens(user) {
+		writeJSONError(w, http.StatusForbidden, "API tokens can only be managed from a session of a local account")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.tokens.List(s.tokenOwnerFilter(user)))
+}
+
+func (s *Server) handleAPICreateToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !canManageTokens(user) {
+		writeJSONError(w, http.StatusForbidden, "API tokens can only be managed from a session of a local account")
+		return
+	}
+
+	var req tokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	token, secret, err := s.tokens.Create(user.Username, req.Name, req.Scopes, time.Duration(req.ExpiresInDays)*24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestLogger(r).Info("API token created", "user", user.Username, "token_id", token.ID, "token_name", token.Name, "scopes", token.Scopes)
+	s.audit(r, "token.create", token.ID, nil, auditToken(token))
+
+	writeJSON(w, http.StatusCreated, createdToken{Token: token.Public(), Secret: secret})
+}
+
+func (s *Server) handleAPIRevokeToken(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if !canManageTokens(user) {
+		writeJSONError(w, http.StatusForbidden, "API tokens can only be managed from a session of a local account")
+		return
+	}
+
+	token, err := s.tokens.Revoke(mux.Vars(r)["id"], s.tokenOwnerFilter(user))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	requestLogger(r).Info("API token revoked", "user", user.Username, "token_id", token.ID, "token_name", token.Name, "target_user", token.Username)
+	s.audit(r, "token.revoke", token.ID, auditToken(token), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) renderTokens(w http.ResponseWriter, r *http.Request, user *User, newSecret, errorMsg string) {
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>API Tokens - WireGuard Easy</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1200px; margin: 0 auto; padding: 20px; }
+        h1 { color: #333; }
+        .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
+        .back { padding: 8px 16px; background: #6c757d; color: white; text-decoration: none; border-radius: 4px; }
+        .back:hover { background: #5a6268; }
+        .add-form { background: #f8f9fa; padding: 20px; border-radius: 8px; margin-bottom: 20px; }
+        .add-form input[type=text], .add-form input[type=number] { padding: 10px; margin-right: 10px; border: 1px solid #ddd; border-radius: 4px; }
+        .add-form label { margin-right: 15px; }
+        .add-form button { padding: 10px 20px; background: #28a745; color: white; border: none; border-radius: 4px; cursor: pointer; }
+        .add-form button:hover { background: #218838; }
+        .error { background: #f8d7da; color: #721c24; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #dc3545; }
+        .success { background: #d4edda; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #28a745; }
+        table { width: 100%; border-collapse: collapse; background: white; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        th, td { padding: 12px; text-align: left; border-bottom: 1px solid #ddd; }
+        th { background: #007bff; color: white; }
+        tr:hover { background: #f8f9fa; }
+        .btn { padding: 6px 12px; text-decoration: none; border-radius: 4px; border: none; cursor: pointer; font-size: 14px; }
+        .btn-delete { background: #dc3545; color: white; }
+        .btn-delete:hover { background: #c82333; }
+        .empty { text-align: center; padding: 40px; color: #666; }
+        .code { font-family: monospace; font-size: 12px; color: #666; }
+        .secret { font-family: monospace; font-size: 16px; word-break: break-all; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>🎫 API Tokens</h1>
+        <a href="{{.BasePath}}/" class="back">← Back to Clients</a>
+    </div>
+
+    {{if .Error}}
+    <div class="error">{{.Error}}</div>
+    {{end}}
+
+    {{if .NewSecret}}
+    <div class="success">
+        <p><strong>Token created.</strong> Copy it now, it will not be shown again:</p>
+        <p class="secret">{{.NewSecret}}</p>
+        <p>Use it as <span class="code">Authorization: Bearer &lt;token&gt;</span> on <span class="code">{{.BasePath}}/api/</span> routes.</p>
+    </div>
+    {{end}}
+
+    <div class="add-form">
+        <h2>New Token</h2>
+        <form method="POST" action="{{.BasePath}}/account/tokens/create">
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+            <input type="text" name="name" placeholder="Name (e.g. provisioning script)" required maxlength="64">
+            {{range .Scopes}}<label><input type="checkbox" name="scopes" value="{{.}}"{{if eq . "read"}} checked{{end}}> {{.}}</label>{{end}}
+            <input type="number" name="expires_in_days" placeholder="Expires in days (empty: never)" min="0" style="width: 220px;">
+            <button type="submit">➕ Create Token</button>
+        </form>
+        <p class="code">Tokens never grant more than your role allows.</p>
+    </div>
+
+    {{if .Tokens}}
+    <table>
+        <thead>
+            <tr>
+                <th>Name</th>
+                {{if .AllTokens}}<th>Owner</th>{{end}}
+                <th>Token</th>
+                <th>Scopes</th>
+                <th>Created</th>
+                <th>Expires</th>
+                <th>Last Used</th>
+                <th>Actions</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Tokens}}
+            <tr>
+                <td><strong>{{.Name}}</strong></td>
+                {{if $.AllTokens}}<td>{{.Username}}</td>{{end}}
+                <td class="code">{{.Hint}}…</td>
+                <td class="code">{{range $i, $s := .Scopes}}{{if $i}}, {{end}}{{$s}}{{end}}</td>
+                <td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td>
+                <td>{{if .ExpiresAt.IsZero}}never{{else}}{{.ExpiresAt.Format "2006-01-02 15:04"}}{{if .Expired $.Now}} (expired){{end}}{{end}}</td>
+                <td>{{if .LastUsed.IsZero}}never{{else}}{{.LastUsed.Format "2006-01-02 15:04"}}{{end}}</td>
+                <td>
+                    <form method="POST" action="{{$.BasePath}}/account/tokens/{{.ID}}/revoke" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-delete" onclick="return confirm('Revoke token {{.Name}}?')">🗑️ Revoke</button>
+                    </form>
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}
+    <div class="empty">No API tokens yet.</div>
+    {{end}}
+</body>
+</html>`
+
+	owner := s.tokenOwnerFilter(user)
+
+	t := template.Must(template.New("tokens").Parse(tmpl))
+	t.Execute(w, map[string]interface{}{
+		"Tokens":    s.tokens.List(owner),
+		"AllTokens": owner == "",
+		"Scopes":    TokenScopes,
+		"NewSecret": newSecret,
+		"Now":       time.Now(),
+		"Error":     errorMsg,
+		"BasePath":  s.basePath(r),
+		"CSRFToken": s.csrfToken(w, r),
+	})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpFile          = "totp.json"
+	totpIssuer        = "WireGuard Easy"
+	totpPeriod        = 30 // seconds
+	totpDigits        = 6
+	totpSkew          = 1 // accept codes one period early or late
+	recoveryCodeCount = 10
+)
+
+var errInvalidTOTPCode = errors.New("invalid authentication code")
+
+// totpEntry is a user's second factor. Recovery codes are stored as SHA-256
+// hashes and removed once used; LastStep prevents a code from being replayed.
+type totpEntry struct {
+	Secret        string    `json:"secret"` // base32
+	Confirmed     bool      `json:"confirmed"`
+	RecoveryCodes []string  `json:"recovery_codes,omitempty"`
+	LastStep      int64     `json:"last_step"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TOTPStore holds the RFC 6238 two-factor enrollments of local accounts,
+// persisted to totp.json in the state directory.
+type TOTPStore struct {
+	config  *Config
+	mu      sync.Mutex
+	entries map[string]*totpEntry // key: username
+}
+
+func NewTOTPStore(config *Config) *TOTPStore {
+	ts := &TOTPStore{
+		config:  config,
+		entries: make(map[string]*totpEntry),
+	}
+	if err := loadState(config, totpFile, &ts.entries); err != nil {
+		slog.Warn("Failed to load two-factor settings", "error", err)
+	}
+	return ts
+}
+
+// save must be called with ts.mu held.
+func (ts *TOTPStore) save() error {
+	return saveState(ts.config, totpFile, ts.entries)
+}
+
+// Enabled reports whether the user has a confirmed second factor.
+func (ts *TOTPStore) Enabled(username string) bool {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry, ok := ts.entries[username]
+	return ok && entry.Confirmed
+}
+
+// RecoveryCodesLeft returns the number of unused recovery codes.
+func (ts *TOTPStore) RecoveryCodesLeft(username string) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if entry, ok := ts.entries[username]; ok {
+		return len(entry.RecoveryCodes)
+	}
+	return 0
+}
+
+// Begin starts enrollment and returns the secret to show to the user. An
+// unconfirmed enrollment is reused so reloading the page keeps the QR code.
+func (ts *TOTPStore) Begin(username string) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if entry, ok := ts.entries[username]; ok {
+		if entry.Confirmed {
+			return "", errors.New("two-factor authentication is already enabled")
+		}
+		return entry.Secret, nil
+	}
+
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	entry := &totpEntry{
+		Secret:    base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret),
+		CreatedAt: time.Now(),
+	}
+	ts.entries[username] = entry
+	if err := ts.save(); err != nil {
+		return "", fmt.Errorf("failed to save two-factor settings: %v", err)
+	}
+	return entry.Secret, nil
+}
+
+// Confirm completes enrollment with a code from the authenticator app and
+// returns the recovery codes, which are only available at this point.
+func (ts *TOTPStore) Confirm(username, code string) ([]string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry, ok := ts.entries[username]
+	if !ok || entry.Confirmed {
+		return nil, errors.New("no two-factor enrollment in progress")
+	}
+	if !entry.verifyCode(code, time.Now()) {
+		return nil, errInvalidTOTPCode
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	entry.Confirmed = true
+	entry.RecoveryCodes = hashes
+	if err := ts.save(); err != nil {
+		return nil, fmt.Errorf("failed to save two-factor settings: %v", err)
+	}
+	return codes, nil
+}
+
+// Verify checks a login code, which is either a current TOTP code or an
+// unused recovery code. Recovery codes are consumed.
+func (ts *TOTPStore) Verify(username, code string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entry, ok := ts.entries[username]
+	if !ok || !entry.Confirmed {
+		return errInvalidTOTPCode
+	}
+
+	if entry.verifyCode(code, time.Now()) {
+		if err := ts.save(); err != nil {
+			slog.Warn("Failed to save two-factor settings", "error", err)
+		}
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, stored := range entry.RecoveryCodes {
+		if constantTimeEqual(stored, hash) {
+			entry.RecoveryCodes = append(entry.RecoveryCodes[:i], entry.RecoveryCodes[i+1:]...)
+			if err := ts.save(); err != nil {
+				slog.Warn("Failed to save two-factor settings", "error", err)
+			}
+			slog.Info("Recovery code used", "user", username, "remaining", len(entry.RecoveryCodes))
+			return nil
+		}
+	}
+	return errInvalidTOTPCode
+}
+
+// Disable removes the user's second factor, including an unfinished
+// enrollment.
+func (ts *TOTPStore) Disable(username string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if _, ok := ts.entries[username]; !ok {
+		return nil
+	}
+	delete(ts.entries, username)
+	return ts.save()
+}
+
+// verifyCode checks a TOTP code against the current time step and its
+// neighbours. Steps at or before the last accepted one are rejected.
+func (e *totpEntry) verifyCode(code string, now time.Time) bool {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return false
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(e.Secret)
+	if err != nil {
+		return false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= e.LastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+			e.LastStep = step
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes the RFC 6238 code (HMAC-SHA1, RFC 4226 truncation) for a
+// time step.
+func totpCode(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// totpURL returns the otpauth:// URI encoded in the enrollment QR code.
+func totpURL(username, secret string) string {
+	params := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	label := url.PathEscape(totpIssuer + ":" + username)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// newRecoveryCodes returns fresh recovery codes (xxxxx-xxxxx) and their
+// hashes.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(b))[:10]
+		code := raw[:5] + "-" + raw[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/skip2/go-qrcode"
+)
+
+// Two-factor authentication handlers
+
+const (
+	totpLoginTimeout     = 5 * time.Minute
+	totpLoginMaxAttempts = 5
+)
+
+// beginTwoFactorLogin records a password-verified user in the session; the
+// session is only authenticated once handleLogin2FA accepts a code.
+func (s *Server) beginTwoFactorLogin(w http.ResponseWriter, r *http.Request, user *User) {
+	session, _ := s.store.Get(r, "session")
+	delete(session.Values, "username")
+	session.Values["pending_2fa"] = user.Username
+	session.Values["pending_2fa_expires"] = time.Now().Add(totpLoginTimeout).Unix()
+	session.Values["pending_2fa_attempts"] = 0
+	session.Save(r, w)
+	s.redirect(w, r, "/login/2fa")
+}
+
+func (s *Server) handleLogin2FA(w http.ResponseWriter, r *http.Request) {
+	session, _ := s.store.Get(r, "session")
+	username, _ := session.Values["pending_2fa"].(string)
+	expires, _ := session.Values["pending_2fa_expires"].(int64)
+	attempts, _ := session.Values["pending_2fa_attempts"].(int)
+
+	if username == "" || time.Now().Unix() > expires || attempts >= totpLoginMaxAttempts {
+		delete(session.Values, "pending_2fa")
+		session.Save(r, w)
+		s.redirect(w, r, "/login")
+		return
+	}
+
+	if r.Method == "GET" {
+		s.renderLogin2FA(w, r, "")
+		return
+	}
+
+	ip := s.clientIP(r)
+	if wait := s.limiter.Check(ip, username); wait > 0 {
+		requestLogger(r).Warn("Login refused, locked out", "user", username, "remote_ip", ip, "method", "totp",
+			"lockout", wait.Round(time.Second).String())
+		metrics.logins.Inc("totp", "locked")
+		s.auditLogin(r, username, "totp", "locked", "")
+		s.renderLogin2FA(w, r, fmt.Sprintf("Too many failed login attempts, try again in %s", wait.Round(time.Second)))
+		return
+	}
+
+	if err := s.totp.Verify(username, r.FormValue("code")); err != nil {
+		session.Values["pending_2fa_attempts"] = attempts + 1
+		session.Save(r, w)
+		failures, lockout := s.limiter.Fail(ip, username)
+		requestLogger(r).Warn("Login failed", "user", username, "remote_ip", ip, "method", "totp",
+			"failures", failures, "lockout", lockout.String())
+		metrics.logins.Inc("totp", "failure")
+		s.auditLogin(r, username, "totp", "failure", "")
+		s.renderLogin2FA(w, r, "Invalid authentication code")
+		return
+	}
+	s.limiter.Succeed(ip, username)
+
+	user, err := s.users.Get(username)
+	if err != nil {
+		s.redirect(w, r, "/login")
+		return
+	}
+
+	delete(session.Values, "pending_2fa")
+	delete(session.Values, "pending_2fa_expires")
+	delete(session.Values, "pending_2fa_attempts")
+	session.Values["username"] = user.Username
+	delete(session.Values, csrfSessionKey)
+	session.Save(r, w)
+	requestLogger(r).Info("User logged in", "user", user.Username, "role", user.Role, "remote_ip", ip, "method", "totp")
+	metrics.logins.Inc("totp", "success")
+	s.auditLogin(r, user.Username, "totp", "success", "")
+	s.redirect(w, r, "/")
+}
+
+func (s *Server) handleAccount2FA(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user.External {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderTwoFactor(w, r, user, nil, "")
+}
+
+func (s *Server) handleEnable2FA(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user.External {
+		http.NotFound(w, r)
+		return
+	}
+
+	codes, err := s.totp.Confirm(user.Username, r.FormValue("code"))
+	if err != nil {
+		s.renderTwoFactor(w, r, user, nil, err.Error())
+		return
+	}
+	requestLogger(r).Info("Two-factor authentication enabled", "user", user.Username)
+	s.audit(r, "2fa.enable", user.Username, nil, nil)
+
+	s.renderTwoFactor(w, r, user, codes, "")
+}
+
+func (s *Server) handleDisable2FA(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user.External {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := s.totp.Verify(user.Username, r.FormValue("code")); err != nil {
+		s.renderTwoFactor(w, r, user, nil, err.Error())
+		return
+	}
+	if err := s.totp.Disable(user.Username); err != nil {
+		s.renderTwoFactor(w, r, user, nil, err.Error())
+		return
+	}
+	requestLogger(r).Info("Two-factor authentication disabled", "user", user.Username)
+	s.audit(r, "2fa.disable", user.Username, nil, nil)
+
+	s.redirect(w, r, "/account/2fa")
+}
+
+// handleReset2FA lets an administrator remove the second factor of a user
+// who lost their authenticator and recovery codes.
+func (s *Server) handleReset2FA(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	if err := s.totp.Disable(username); err != nil {
+		s.renderUsers(w, r, currentUser(r), err.Error())
+		return
+	}
+	requestLogger(r).Info("Two-factor authentication reset", "user", currentUser(r).Username, "target_user", username)
+	s.audit(r, "2fa.reset", username, nil, nil)
+
+	s.redirect(w, r, "/users")
+}
+
+func (s *Server) renderLogin2FA(w http.ResponseWriter, r *http.Request, errorMsg string) {
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>WireGuard Easy - Two-Factor Authentication</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 400px; margin: 100px auto; padding: 20px; }
+        input { width: 100%; padding: 10px; margin: 10px 0; box-sizing: border-box; }
+        button { width: 100%; padding: 10px; background: #007bff; color: white; border: none; cursor: pointer; }
+        button:hover { background: #0056b3; }
+        .error { color: red; margin: 10px 0; }
+        .hint { color: #666; font-size: 14px; }
+        h1 { text-align: center; }
+    </style>
+</head>
+<body>
+    <h1>🔒 WireGuard Easy</h1>
+    {{if .Error}}<div class="error">{{.Error}}</div>{{end}}
+    <form method="POST" action="{{.BasePath}}/login/2fa">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="text" name="code" placeholder="Authentication code" required autofocus autocomplete="one-time-code" inputmode="numeric">
+        <button type="submit">Verify</button>
+    </form>
+    <p class="hint">Enter the 6-digit code from your authenticator app, or one of your recovery codes.</p>
+</body>
+</html>`
+
+	t := template.Must(template.New("login2fa").Parse(tmpl))
+	t.Execute(w, map[string]interface{}{
+		"Error":     errorMsg,
+		"BasePath":  s.basePath(r),
+		"CSRFToken": s.csrfToken(w, r),
+	})
+}
+
+func (s *Server) renderTwoFactor(w http.ResponseWriter, r *http.Request, user *User, recoveryCodes []string, errorMsg string) {
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Two-Factor Authentication - WireGuard Easy</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 800px; margin: 0 auto; padding: 20px; }
+        h1 { color: #333; }
+        .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
+        .back { padding: 8px 16px; background: #6c757d; color: white; text-decoration: none; border-radius: 4px; }
+        .back:hover { background: #5a6268; }
+        .box { background: #f8f9fa; padding: 20px; border-radius: 8px; margin-bottom: 20px; }
+        .box input { padding: 10px; margin-right: 10px; border: 1px solid #ddd; border-radius: 4px; }
+        .box button { padding: 10px 20px; background: #28a745; color: white; border: none; border-radius: 4px; cursor: pointer; }
+        .box button:hover { background: #218838; }
+        .box button.btn-delete { background: #dc3545; }
+        .box button.btn-delete:hover { background: #c82333; }
+        .error { background: #f8d7da; color: #721c24; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #dc3545; }
+        .success { background: #d4edda; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #28a745; }
+        .code { font-family: monospace; font-size: 14px; }
+        .codes { columns: 2; font-family: monospace; font-size: 16px; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>🔑 Two-Factor Authentication</h1>
+        <a href="{{.BasePath}}/" class="back">← Back to Clients</a>
+    </div>
+
+    {{if .Error}}
+    <div class="error">{{.Error}}</div>
+    {{end}}
+
+    {{if .RecoveryCodes}}
+    <div class="success">
+        <p><strong>Two-factor authentication is enabled.</strong> Store these recovery codes somewhere safe.
+        Each can be used once instead of a code from your app. They will not be shown again.</p>
+        <ul class="codes">{{range .RecoveryCodes}}<li>{{.}}</li>{{end}}</ul>
+    </div>
+    {{else if .Enabled}}
+    <div class="box">
+        <p>Two-factor authentication is <strong>enabled</strong> for {{.User.Username}}. {{.RecoveryCodesLeft}} recovery codes left.</p>
+        <form method="POST" action="{{.BasePath}}/account/2fa/disable">
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+            <input type="text" name="code" placeholder="Code or recovery code" required autocomplete="one-time-code">
+            <button type="submit" class="btn-delete">Disable</button>
+        </form>
+    </div>
+    {{else}}
+    <div class="box">
+        <p>Scan this QR code with an authenticator app (e.g. Aegis, Google Authenticator, 1Password), then enter the code it shows.</p>
+        <img src="{{.QRCode}}" alt="TOTP QR code" width="256" height="256">
+        <p>Or enter the key manually: <span class="code">{{.Secret}}</span></p>
+        <form method="POST" action="{{.BasePath}}/account/2fa/enable">
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+            <input type="text" name="code" placeholder="6-digit code" required autocomplete="one-time-code" inputmode="numeric">
+            <button type="submit">Enable</button>
+        </form>
+    </div>
+    {{end}}
+</body>
+</html>`
+
+	data := map[string]interface{}{
+		"User":              user,
+		"Enabled":           s.totp.Enabled(user.Username),
+		"RecoveryCodes":     recoveryCodes,
+		"RecoveryCodesLeft": s.totp.RecoveryCodesLeft(user.Username),
+		"Error":             errorMsg,
+		"BasePath":          s.basePath(r),
+		"CSRFToken":         s.csrfToken(w, r),
+	}
+
+	if recoveryCodes == nil && !s.totp.Enabled(user.Username) {
+		secret, err := s.totp.Begin(user.Username)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		png, err := qrcode.Encode(totpURL(user.Username, secret), qrcode.Medium, 256)
+		if err != nil {
+			http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+			return
+		}
+		data["Secret"] = secret
+		data["QRCode"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	t := template.Must(template.New("twofactor").Parse(tmpl))
+	t.Execute(w, data)
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// User management handlers
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	s.renderUsers(w, r, currentUser(r), "")
+}
+
+func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	username := r.FormValue("username")
+	role := Role(r.FormValue("role"))
+
+	user, err := s.users.Create(username, r.FormValue("password"), role)
+	if err != nil {
+		s.renderUsers(w, r, currentUser(r), err.Error())
+		return
+	}
+	requestLogger(r).Info("User created", "user", currentUser(r).Username, "target_user", username, "role", role)
+	s.audit(r, "user.create", username, nil, auditUser(user))
+
+	s.redirect(w, r, "/users")
+}
+
+func (s *Server) handleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+	role := Role(r.FormValue("role"))
+
+	before, _ := s.users.Get(username)
+	user, err := s.users.Update(username, role, r.FormValue("password"))
+	if err != nil {
+		s.renderUsers(w, r, currentUser(r), err.Error())
+		return
+	}
+	requestLogger(r).Info("User updated", "user", currentUser(r).Username, "target_user", username, "role", role)
+	s.audit(r, "user.update", username, auditUser(before), auditUser(user))
+
+	s.redirect(w, r, "/users")
+}
+
+func (s *Server) handleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	before, _ := s.users.Get(username)
+	if err := s.users.Delete(username); err != nil {
+		s.renderUsers(w, r, currentUser(r), err.Error())
+		return
+	}
+	if err := s.totp.Disable(username); err != nil {
+		requestLogger(r).Warn("Failed to remove two-factor settings", "target_user", username, "error", err)
+	}
+	if err := s.tokens.RevokeAll(username); err != nil {
+		requestLogger(r).Warn("Failed to remove API tokens", "target_user", username, "error", err)
+	}
+	requestLogger(r).Info("User deleted", "user", currentUser(r).Username, "target_user", username)
+	s.audit(r, "user.delete", username, auditUser(before), nil)
+
+	s.redirect(w, r, "/users")
+}
+
+func (s *Server) handleClearLockout(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+
+	if err := s.limiter.Clear(key); err != nil {
+		s.renderUsers(w, r, currentUser(r), err.Error())
+		return
+	}
+	requestLogger(r).Info("Login lockout cleared", "user", currentUser(r).Username, "lockout", key)
+	s.audit(r, "lockout.clear", key, nil, nil)
+
+	s.redirect(w, r, "/users")
+}
+
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     Role   `json:"role"`
+}
+
+func (s *Server) handleAPIUsers(w http.ResponseWriter, r *http.Request) {
+	users := s.users.List()
+	result := make([]User, 0, len(users))
+	for _, user := range users {
+		result = append(result, user.Public())
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleAPICreateUser(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	user, err := s.users.Create(req.Username, req.Password, req.Role)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestLogger(r).Info("User created", "user", currentUser(r).Username, "target_user", user.Username, "role", user.Role)
+	s.audit(r, "user.create", user.Username, nil, auditUser(user))
+
+	writeJSON(w, http.StatusCreated, user.Public())
+}
+
+func (s *Server) handleAPIUpdateUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	existing, err := s.users.Get(username)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	req := userRequest{Role: existing.Role}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	user, err := s.users.Update(username, req.Role, req.Password)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	requestLogger(r).Info("User updated", "user", currentUser(r).Username, "target_user", user.Username, "role", user.Role)
+	s.audit(r, "user.update", user.Username, auditUser(existing), auditUser(user))
+
+	writeJSON(w, http.StatusOK, user.Public())
+}
+
+func (s *Server) handleAPIDeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := mux.Vars(r)["username"]
+
+	before, _ := s.users.Get(username)
+	if err := s.users.Delete(username); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.totp.Disable(username); err != nil {
+		requestLogger(r).Warn("Failed to remove two-factor settings", "target_user", username, "error", err)
+	}
+	if err := s.tokens.RevokeAll(username); err != nil {
+		requestLogger(r).Warn("Failed to remove API tokens", "target_user", username, "error", err)
+	}
+	requestLogger(r).Info("User deleted", "user", currentUser(r).Username, "target_user", username)
+	s.audit(r, "user.delete", username, auditUser(before), nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAPILockouts(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.limiter.List())
+}
+
+func (s *Server) handleAPIClearLockout(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+
+	if err := s.limiter.Clear(key); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	requestLogger(r).Info("Login lockout cleared", "user", currentUser(r).Username, "lockout", key)
+	s.audit(r, "lockout.clear", key, nil, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes {"error": message, "code": code}, with the code
+// derived from the status (e.g. "not_found", "conflict").
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	code := strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	writeJSON(w, status, map[string]string{"error": message, "code": code})
+}
+
+func (s *Server) renderUsers(w http.ResponseWriter, r *http.Request, user *User, errorMsg string) {
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Users - WireGuard Easy</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1200px; margin: 0 auto; padding: 20px; }
+        h1 { color: #333; }
+        .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
+        .back { padding: 8px 16px; background: #6c757d; color: white; text-decoration: none; border-radius: 4px; }
+        .back:hover { background: #5a6268; }
+        .add-form { background: #f8f9fa; padding: 20px; border-radius: 8px; margin-bottom: 20px; }
+        .add-form input, .add-form select { padding: 10px; margin-right: 10px; border: 1px solid #ddd; border-radius: 4px; }
+        .add-form button { padding: 10px 20px; background: #28a745; color: white; border: none; border-radius: 4px; cursor: pointer; }
+        .add-form button:hover { background: #218838; }
+        .error { background: #f8d7da; color: #721c24; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #dc3545; }
+        table { width: 100%; border-collapse: collapse; background: white; box-shadow: 0 2px 4px rgba(0,0,0,0.1); }
+        th, td { padding: 12px; text-align: left; border-bottom: 1px solid #ddd; }
+        th { background: #007bff; color: white; }
+        tr:hover { background: #f8f9fa; }
+        td input, td select { padding: 6px; border: 1px solid #ddd; border-radius: 4px; }
+        .actions { display: flex; gap: 10px; }
+        .btn { padding: 6px 12px; text-decoration: none; border-radius: 4px; border: none; cursor: pointer; font-size: 14px; }
+        .btn-save { background: #17a2b8; color: white; }
+        .btn-save:hover { background: #138496; }
+        .btn-delete { background: #dc3545; color: white; }
+        .btn-delete:hover { background: #c82333; }
+        .btn-reset { background: #ffc107; color: #333; }
+        .btn-reset:hover { background: #e0a800; }
+        .code { font-family: monospace; font-size: 12px; color: #666; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>👥 Users</h1>
+        <a href="{{.BasePath}}/" class="back">← Back to Clients</a>
+    </div>
+
+    {{if .Error}}
+    <div class="error">{{.Error}}</div>
+    {{end}}
+
+    <div class="add-form">
+        <h2>Add User</h2>
+        <form method="POST" action="{{.BasePath}}/users/create">
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+            <input type="text" name="username" placeholder="Username" required>
+            <input type="password" name="password" placeholder="Password (min. 8 characters)" minlength="8" required autocomplete="new-password">
+            <select name="role">
+                {{range .Roles}}<option value="{{.}}"{{if eq . "viewer"}} selected{{end}}>{{.}}</option>{{end}}
+            </select>
+            <button type="submit">➕ Add User</button>
+        </form>
+    </div>
+
+    <table>
+        <thead>
+            <tr>
+                <th>Username</th>
+                <th>Role</th>
+                <th>2FA</th>
+                <th>Created</th>
+                <th>Actions</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Users}}
+            <tr>
+                <td><strong>{{.Username}}</strong>{{if eq .Username $.User.Username}} (you){{end}}</td>
+                {{if .BuiltIn}}
+                <td>{{.Role}}</td>
+                <td>{{if index $.TwoFactor .Username}}✅{{else}}—{{end}}</td>
+                <td class="code">config.json</td>
+                <td class="actions">
+                    {{if index $.TwoFactor .Username}}
+                    <form method="POST" action="{{$.BasePath}}/users/{{.Username}}/2fa/reset" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-reset" onclick="return confirm('Reset two-factor authentication of {{.Username}}?')">🔑 Reset 2FA</button>
+                    </form>
+                    {{end}}
+                </td>
+                {{else}}
+                <td>
+                    <form id="update-{{.Username}}" method="POST" action="{{$.BasePath}}/users/{{.Username}}/update">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <select name="role">
+                            {{$role := .Role}}
+                            {{range $.Roles}}<option value="{{.}}"{{if eq . $role}} selected{{end}}>{{.}}</option>{{end}}
+                        </select>
+                        <input type="password" name="password" placeholder="New password (optional)" autocomplete="new-password">
+                    </form>
+                </td>
+                <td>{{if index $.TwoFactor .Username}}✅{{else}}—{{end}}</td>
+                <td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td>
+                <td class="actions">
+                    <button type="submit" form="update-{{.Username}}" class="btn btn-save">💾 Save</button>
+                    {{if index $.TwoFactor .Username}}
+                    <form method="POST" action="{{$.BasePath}}/users/{{.Username}}/2fa/reset" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-reset" onclick="return confirm('Reset two-factor authentication of {{.Username}}?')">🔑 Reset 2FA</button>
+                    </form>
+                    {{end}}
+                    <form method="POST" action="{{$.BasePath}}/users/{{.Username}}/delete" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-delete" onclick="return confirm('Delete user {{.Username}}?')">🗑️ Delete</button>
+                    </form>
+                </td>
+                {{end}}
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+
+    <h2>Failed Logins</h2>
+    {{if .Lockouts}}
+    <table>
+        <thead>
+            <tr>
+                <th>IP / Account</th>
+                <th>Failures</th>
+                <th>Last Failure</th>
+                <th>Locked Until</th>
+                <th>Actions</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Lockouts}}
+            <tr>
+                <td class="code">{{.Key}}</td>
+                <td>{{.Failures}}</td>
+                <td>{{.LastFailure.Format "2006-01-02 15:04:05"}}</td>
+                <td>{{if .Locked $.Now}}🔒 {{.LockedUntil.Format "15:04:05"}}{{else}}—{{end}}</td>
+                <td class="actions">
+                    <form method="POST" action="{{$.BasePath}}/users/lockouts/clear" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <input type="hidden" name="key" value="{{.Key}}">
+                        <button type="submit" class="btn btn-reset">🔓 Clear</button>
+                    </form>
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}
+    <p class="code">No recent failed logins.</p>
+    {{end}}
+</body>
+</html>`
+
+	users := s.users.List()
+	twoFactor := make(map[string]bool, len(users))
+	for _, u := range users {
+		twoFactor[u.Username] = s.totp.Enabled(u.Username)
+	}
+
+	t := template.Must(template.New("users").Parse(tmpl))
+	t.Execute(w, map[string]interface{}{
+		"User":      user,
+		"Users":     users,
+		"TwoFactor": twoFactor,
+		"Lockouts":  s.limiter.List(),
+		"Now":       time.Now(),
+		"Roles":     Roles,
+		"Error":     errorMsg,
+		"BasePath":  s.basePath(r),
+		"CSRFToken": s.csrfToken(w, r),
+	})
+}
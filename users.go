@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const usersFile = "users.json"
+
+type Role string
+
+const (
+	RoleAdmin    Role = "admin"    // Everything, including user management
+	RoleOperator Role = "operator" // Create, disable and download clients, manage forwards
+	RoleViewer   Role = "viewer"   // Read-only
+	RoleUser     Role = "user"     // Self-service: only clients they own
+)
+
+type Permission string
+
+const (
+	PermViewClients        Permission = "clients:view"
+	PermCreateClients      Permission = "clients:create"
+	PermEditClients        Permission = "clients:edit" // rename, allowed IPs, new keys
+	PermToggleClients      Permission = "clients:toggle"
+	PermDeleteClients      Permission = "clients:delete"
+	PermDownloadConfigs    Permission = "clients:download"
+	PermViewPortForwards   Permission = "portforwards:view"
+	PermManagePortForwards Permission = "portforwards:manage"
+	PermManageUsers        Permission = "users:manage"
+	PermManageWebhooks     Permission = "webhooks:manage"
+	PermViewAudit          Permission = "audit:view"
+	PermManageConfig       Permission = "config:manage" // edit settings and reload the configuration
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermViewClients, PermCreateClients, PermEditClients, PermToggleClients, PermDeleteClients,
+		PermDownloadConfigs, PermViewPortForwards, PermManagePortForwards, PermManageUsers,
+		PermManageWebhooks, PermViewAudit, PermManageConfig,
+	},
+	RoleOperator: {
+		PermViewClients, PermCreateClients, PermEditClients, PermToggleClients,
+		PermDownloadConfigs, PermViewPortForwards, PermManagePortForwards,
+	},
+	RoleViewer: {
+		PermViewClients, PermViewPortForwards,
+	},
+	// Restricted to owned clients, see User.OwnClientsOnly
+	RoleUser: {
+		PermViewClients, PermCreateClients, PermEditClients, PermDeleteClients,
+		PermDownloadConfigs, PermViewPortForwards,
+	},
+}
+
+// Roles lists the assignable roles, most privileged first.
+var Roles = []Role{RoleAdmin, RoleOperator, RoleViewer, RoleUser}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// mapRole returns the most privileged role that mapping assigns to any of the
+// values (e.g. groups of an SSO user), or fallback if none matches.
+func mapRole(values []string, mapping map[string]Role, fallback Role) Role {
+	matched := make(map[Role]bool)
+	for _, value := range values {
+		if role, ok := mapping[value]; ok {
+			matched[role] = true
+		}
+	}
+	for _, role := range Roles {
+		if matched[role] {
+			return role
+		}
+	}
+	return fallback
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._@-]{1,64}$`)
+
+// Prefixes of SSO and proxy usernames. Local usernames cannot contain ':',
+// so an external user never shares clients or an audit identity with a
+// local account of the same name.
+const (
+	oidcUserPrefix  = "oidc:"
+	proxyUserPrefix = "proxy:"
+)
+
+// isExternalUsername reports whether username belongs to an SSO or proxy
+// user.
+func isExternalUsername(username string) bool {
+	return strings.HasPrefix(username, oidcUserPrefix) || strings.HasPrefix(username, proxyUserPrefix)
+}
+
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         Role      `json:"role"`
+	CreatedAt    time.Time `json:"created_at"`
+	BuiltIn      bool      `json:"built_in,omitempty"` // the admin account from config.json
+	External     bool      `json:"external,omitempty"` // signed in via OIDC or the proxy, not in users.json
+
+	// TokenPerms further restricts the role when the request is
+	// authenticated with an API token.
+	TokenPerms []Permission `json:"-"`
+}
+
+// Can reports whether the user's role (and API token, if any) grants the
+// permission.
+func (u *User) Can(perm Permission) bool {
+	if u == nil {
+		return false
+	}
+	if u.TokenPerms != nil && !slices.Contains(u.TokenPerms, perm) {
+		return false
+	}
+	for _, p := range rolePermissions[u.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// OwnClientsOnly reports whether the user is limited to the clients they own.
+func (u *User) OwnClientsOnly() bool {
+	return u.Role == RoleUser
+}
+
+// Public returns a copy of the user without credentials, for display and API
+// responses.
+func (u *User) Public() User {
+	public := *u
+	public.PasswordHash = ""
+	return public
+}
+
+// UserStore holds the user accounts, persisted to users.json in the state
+// directory. The admin account from config.json (admin_username with
+// admin_password_hash or admin_password) is always present and cannot be
+// changed from the UI.
+type UserStore struct {
+	config *Config
+	mu     sync.RWMutex
+	users  map[string]*User
+}
+
+func NewUserStore(config *Config) *UserStore {
+	us := &UserStore{
+		config: config,
+		users:  make(map[string]*User),
+	}
+
+	var users []*User
+	if err := loadState(config, usersFile, &users); err != nil {
+		slog.Warn("Failed to load users", "error", err)
+	}
+	for _, user := range users {
+		if user.Username == config.AdminUsername {
+			continue // Shadowed by the built-in admin
+		}
+		us.users[user.Username] = user
+	}
+
+	return us
+}
+
+func (us *UserStore) builtInAdmin() *User {
+	return &User{
+		Username: us.config.AdminUsername,
+		Role:     RoleAdmin,
+		BuiltIn:  true,
+	}
+}
+
+// save must be called with us.mu held.
+func (us *UserStore) save() error {
+	users := make([]*User, 0, len(us.users))
+	for _, user := range us.users {
+		users = append(users, user)
+	}
+	return saveState(us.config, usersFile, users)
+}
+
+// Authenticate returns the user if the password is correct.
+func (us *UserStore) Authenticate(username, password string) (*User, bool) {
+	if username == us.config.AdminUsername {
+		if us.config.AdminPasswordHash != "" {
+			return us.builtInAdmin(), VerifyPassword(us.config.AdminPasswordHash, password)
+		}
+		if us.config.AdminPassword == "" {
+			return nil, false
+		}
+		return us.builtInAdmin(), constantTimeEqual(password, us.config.AdminPassword)
+	}
+
+	us.mu.RLock()
+	user, exists := us.users[username]
+	us.mu.RUnlock()
+
+	if !exists {
+		// Burn the same time as a real check so usernames can't be probed
+		VerifyPassword(dummyPasswordHash(), password)
+		return nil, false
+	}
+	if !VerifyPassword(user.PasswordHash, password) {
+		return nil, false
+	}
+	return user, true
+}
+
+// dummyPasswordHash is verified against for unknown users.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("dummy-password", false)
+	return hash
+})
+
+func (us *UserStore) Get(username string) (*User, error) {
+	if username == us.config.AdminUsername {
+		return us.builtInAdmin(), nil
+	}
+
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	user, exists := us.users[username]
+	if !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+	return user, nil
+}
+
+// List returns all users sorted by name, the built-in admin first.
+func (us *UserStore) List() []*User {
+	us.mu.RLock()
+	defer us.mu.RUnlock()
+
+	users := make([]*User, 0, len(us.users)+1)
+	for _, user := range us.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return append([]*User{us.builtInAdmin()}, users...)
+}
+
+func (us *UserStore) Create(username, password string, role Role) (*User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, fmt.Errorf("invalid username")
+	}
+	if !role.Valid() {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+	if len(password) < 8 {
+		return nil, fmt.Errorf("password must be at least 8 characters")
+	}
+	if username == us.config.AdminUsername {
+		return nil, fmt.Errorf("user already exists")
+	}
+
+	hash, err := HashPassword(password, false)
+	if err != nil {
+		return nil, err
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	if _, exists := us.users[username]; exists {
+		return nil, fmt.Errorf("user already exists")
+	}
+
+	user := &User{
+		Username:     username,
+		PasswordHash: hash,
+		Role:         role,
+		CreatedAt:    time.Now(),
+	}
+	us.users[username] = user
+
+	if err := us.save(); err != nil {
+		delete(us.users, username)
+		return nil, fmt.Errorf("failed to save users: %v", err)
+	}
+	return user, nil
+}
+
+// Update changes a user's role and, if password is not empty, its password.
+func (us *UserStore) Update(username string, role Role, password string) (*User, error) {
+	if username == us.config.AdminUsername {
+		return nil, fmt.Errorf("the built-in admin is configured in config.json")
+	}
+	if !role.Valid() {
+		return nil, fmt.Errorf("invalid role %q", role)
+	}
+
+	var hash string
+	if password != "" {
+		if len(password) < 8 {
+			return nil, fmt.Errorf("password must be at least 8 characters")
+		}
+		var err error
+		if hash, err = HashPassword(password, false); err != nil {
+			return nil, err
+		}
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	existing, exists := us.users[username]
+	if !exists {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	updated := *existing
+	updated.Role = role
+	if hash != "" {
+		updated.PasswordHash = hash
+	}
+	us.users[username] = &updated
+
+	if err := us.save(); err != nil {
+		us.users[username] = existing
+		return nil, fmt.Errorf("failed to save users: %v", err)
+	}
+	return &updated, nil
+}
+
+func (us *UserStore) Delete(username string) error {
+	if username == us.config.AdminUsername {
+		return fmt.Errorf("the built-in admin cannot be deleted")
+	}
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	user, exists := us.users[username]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	delete(us.users, username)
+
+	if err := us.save(); err != nil {
+		us.users[username] = user
+		return fmt.Errorf("failed to save users: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Webhook delivery log handlers
+
+func (s *Server) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	s.renderWebhooks(w, r, currentUser(r), "")
+}
+
+func (s *Server) handleTestWebhook(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	if err := s.webhooks.SendTest(name, currentUser(r).Username); err != nil {
+		s.renderWebhooks(w, r, currentUser(r), err.Error())
+		return
+	}
+	requestLogger(r).Info("Webhook test event sent", "user", currentUser(r).Username, "webhook", name)
+	s.audit(r, "webhook.test", name, nil, nil)
+
+	s.redirect(w, r, "/webhooks")
+}
+
+func (s *Server) handleRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := s.webhooks.Retry(id); err != nil {
+		s.renderWebhooks(w, r, currentUser(r), err.Error())
+		return
+	}
+	requestLogger(r).Info("Webhook delivery retried", "user", currentUser(r).Username, "delivery_id", id)
+	s.audit(r, "webhook.retry", id, nil, nil)
+
+	s.redirect(w, r, "/webhooks")
+}
+
+type webhookDeliveries struct {
+	Pending []WebhookDelivery `json:"pending"`
+	Log     []WebhookDelivery `json:"log"`
+}
+
+func (s *Server) handleAPIWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	pending, finished := s.webhooks.Deliveries()
+	writeJSON(w, http.StatusOK, webhookDeliveries{Pending: pending, Log: finished})
+}
+
+func (s *Server) handleAPIRetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	if err := s.webhooks.Retry(id); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	requestLogger(r).Info("Webhook delivery retried", "user", currentUser(r).Username, "delivery_id", id)
+	s.audit(r, "webhook.retry", id, nil, nil)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) renderWebhooks(w http.ResponseWriter, r *http.Request, user *User, errorMsg string) {
+	tmpl := `<!DOCTYPE html>
+<html>
+<head>
+    <title>Webhooks - WireGuard Easy</title>
+    <style>
+        body { font-family: Arial, sans-serif; max-width: 1200px; margin: 0 auto; padding: 20px; }
+        h1 { color: #333; }
+        .header { display: flex; justify-content: space-between; align-items: center; margin-bottom: 20px; }
+        .back { padding: 8px 16px; background: #6c757d; color: white; text-decoration: none; border-radius: 4px; }
+        .back:hover { background: #5a6268; }
+        .error { background: #f8d7da; color: #721c24; padding: 12px; border-radius: 4px; margin-bottom: 20px; border-left: 4px solid #dc3545; }
+        .empty { color: #666; }
+        table { width: 100%; border-collapse: collapse; background: white; box-shadow: 0 2px 4px rgba(0,0,0,0.1); margin-bottom: 20px; }
+        th, td { padding: 12px; text-align: left; border-bottom: 1px solid #ddd; }
+        th { background: #007bff; color: white; }
+        tr:hover { background: #f8f9fa; }
+        .btn { padding: 6px 12px; text-decoration: none; border-radius: 4px; border: none; cursor: pointer; font-size: 14px; }
+        .btn-retry { background: #17a2b8; color: white; }
+        .btn-retry:hover { background: #138496; }
+        .code { font-family: monospace; font-size: 12px; color: #666; }
+        .state { padding: 2px 8px; border-radius: 4px; font-size: 12px; }
+        .state-pending { background: #fff3cd; }
+        .state-delivered { background: #d4edda; }
+        .state-failed { background: #f8d7da; }
+    </style>
+</head>
+<body>
+    <div class="header">
+        <h1>🪝 Webhooks</h1>
+        <a href="{{.BasePath}}/" class="back">← Back to Clients</a>
+    </div>
+
+    {{if .Error}}
+    <div class="error">{{.Error}}</div>
+    {{end}}
+
+    {{if .Webhooks}}
+    <table>
+        <thead>
+            <tr>
+                <th>Name</th>
+                <th>URL</th>
+                <th>Events</th>
+                <th>Queued</th>
+                <th>Actions</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Webhooks}}
+            <tr>
+                <td><strong>{{.Name}}</strong></td>
+                <td class="code">{{redactURL .URL}}</td>
+                <td class="code">{{if .Events}}{{join .Events ", "}}{{else}}all{{end}}</td>
+                <td>{{index $.Queued .Name}}</td>
+                <td>
+                    <form method="POST" action="{{$.BasePath}}/webhooks/{{.Name}}/test" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-retry">📨 Send test</button>
+                    </form>
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}
+    <p class="empty">No webhooks configured. Add them to the <code>webhooks</code> section of config.json.</p>
+    {{end}}
+
+    <h2>Queue</h2>
+    {{if .Pending}}
+    <table>
+        <thead>
+            <tr>
+                <th>Created</th>
+                <th>Webhook</th>
+                <th>Event</th>
+                <th>Attempts</th>
+                <th>Next Attempt</th>
+                <th>Last Error</th>
+                <th>Actions</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Pending}}
+            <tr>
+                <td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+                <td>{{.Webhook}}</td>
+                <td class="code">{{.EventType}}</td>
+                <td>{{.Attempts}}</td>
+                <td>{{.NextAttempt.Format "15:04:05"}}</td>
+                <td class="code">{{.LastError}}</td>
+                <td>
+                    <form method="POST" action="{{$.BasePath}}/webhooks/deliveries/{{.ID}}/retry" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-retry">🔁 Retry now</button>
+                    </form>
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}
+    <p class="empty">Nothing queued.</p>
+    {{end}}
+
+    <h2>Delivery Log</h2>
+    {{if .Log}}
+    <table>
+        <thead>
+            <tr>
+                <th>Last Attempt</th>
+                <th>Webhook</th>
+                <th>Event</th>
+                <th>State</th>
+                <th>Attempts</th>
+                <th>Status</th>
+                <th>Error</th>
+                <th>Actions</th>
+            </tr>
+        </thead>
+        <tbody>
+            {{range .Log}}
+            <tr>
+                <td>{{.LastAttempt.Format "2006-01-02 15:04:05"}}</td>
+                <td>{{.Webhook}}</td>
+                <td class="code">{{.EventType}}</td>
+                <td><span class="state state-{{.State}}">{{.State}}</span></td>
+                <td>{{.Attempts}}</td>
+                <td>{{if .LastStatus}}{{.LastStatus}}{{else}}—{{end}}</td>
+                <td class="code">{{.LastError}}</td>
+                <td>
+                    <form method="POST" action="{{$.BasePath}}/webhooks/deliveries/{{.ID}}/retry" style="display: inline;">
+                        <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                        <button type="submit" class="btn btn-retry">🔁 Redeliver</button>
+                    </form>
+                </td>
+            </tr>
+            {{end}}
+        </tbody>
+    </table>
+    {{else}}
+    <p class="empty">No deliveries yet.</p>
+    {{end}}
+</body>
+</html>`
+
+	pending, finished := s.webhooks.Deliveries()
+
+	t := template.Must(template.New("webhooks").Funcs(template.FuncMap{
+		"join": strings.Join,
+		// URLs of chat webhooks often contain a secret in the path
+		"redactURL": func(raw string) string {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return ""
+			}
+			if u.Path == "" || u.Path == "/" {
+				return u.Scheme + "://" + u.Host
+			}
+			return u.Scheme + "://" + u.Host + "/…"
+		},
+	}).Parse(tmpl))
+	t.Execute(w, map[string]interface{}{
+		"User":      user,
+		"Webhooks":  s.config.Webhooks,
+		"Queued":    s.webhooks.PendingCount(),
+		"Pending":   pending,
+		"Log":       finished,
+		"Error":     errorMsg,
+		"BasePath":  s.basePath(r),
+		"CSRFToken": s.csrfToken(w, r),
+	})
+}
@@ -0,0 +1,569 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Outgoing webhooks. Events from the event bus that match a configured
+// webhook are queued as deliveries in webhooks.json and POSTed with
+// exponential backoff until the endpoint answers 2xx or the attempts run out.
+// Each webhook is delivered to on its own goroutine, in queue order, so a
+// slow or failing endpoint does not hold up the others.
+
+const (
+	webhookStateFile   = "webhooks.json"
+	webhookLogSize     = 200
+	webhookMaxAttempts = 10
+	webhookRetryBase   = 30 * time.Second // doubled after every failed attempt
+	webhookRetryMax    = time.Hour
+	webhookTimeout     = 10 * time.Second
+	webhookMaxPending  = 1000 // per webhook, the oldest deliveries are dropped beyond it
+)
+
+// EventWebhookTest is sent by the "Send test" button; it is never published
+// on the bus.
+const EventWebhookTest EventType = "webhook.test"
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookConfig struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"` // HMAC-SHA256 key for X-Webhook-Signature
+	Events []string `json:"events"` // event types or prefixes like "client.*"; empty: all
+}
+
+// Matches reports whether the webhook subscribes to the event type.
+func (c *WebhookConfig) Matches(eventType EventType) bool {
+	if len(c.Events) == 0 || eventType == EventWebhookTest {
+		return true
+	}
+	for _, pattern := range c.Events {
+		if matchEventType(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchEventType(pattern string, eventType EventType) bool {
+	if pattern == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(string(eventType), prefix)
+	}
+	return pattern == string(eventType)
+}
+
+// validateWebhooks checks the webhooks section of the config.
+func validateWebhooks(webhooks []WebhookConfig) error {
+	names := make(map[string]bool)
+	for _, webhook := range webhooks {
+		if webhook.Name == "" {
+			return fmt.Errorf("webhook without name")
+		}
+		if names[webhook.Name] {
+			return fmt.Errorf("duplicate webhook name %q", webhook.Name)
+		}
+		names[webhook.Name] = true
+
+		u, err := url.Parse(webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook %q: invalid url %q", webhook.Name, webhook.URL)
+		}
+		if webhook.Secret == "" {
+			return fmt.Errorf("webhook %q: secret is required", webhook.Name)
+		}
+		for _, pattern := range webhook.Events {
+			known := false
+			for _, eventType := range EventTypes {
+				if matchEventType(pattern, eventType) {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return fmt.Errorf("webhook %q: unknown event type %q", webhook.Name, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// WebhookDelivery is one event queued for or delivered to one webhook. The
+// payload is signed and sent as is on every attempt.
+type WebhookDelivery struct {
+	ID          string          `json:"id"`
+	Webhook     string          `json:"webhook"`
+	EventType   EventType       `json:"event_type"`
+	Payload     json.RawMessage `json:"payload"`
+	State       string          `json:"state"`
+	Attempts    int             `json:"attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	NextAttempt time.Time       `json:"next_attempt,omitzero"`
+	LastAttempt time.Time       `json:"last_attempt,omitzero"`
+	LastStatus  int             `json:"last_status,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+type webhookState struct {
+	Pending []*WebhookDelivery `json:"pending"`
+	Log     []*WebhookDelivery `json:"log"` // finished deliveries, oldest first
+}
+
+type WebhookDispatcher struct {
+	config  *Config
+	client  *http.Client
+	mu      sync.Mutex
+	state   webhookState
+	dirty   bool                 // queued deliveries not saved yet
+	busy    map[string]bool      // webhooks a worker is delivering to
+	blocked map[string]time.Time // webhooks held back after a failed attempt
+	workers sync.WaitGroup
+	wake    chan struct{}
+}
+
+// NewWebhookDispatcher loads the queue and subscribes to bus right away, so
+// no event published before Run is missed.
+func NewWebhookDispatcher(config *Config, bus *EventBus) *WebhookDispatcher {
+	d := &WebhookDispatcher{
+		config:  config,
+		client:  &http.Client{Timeout: webhookTimeout},
+		busy:    make(map[string]bool),
+		blocked: make(map[string]time.Time),
+		wake:    make(chan struct{}, 1),
+	}
+	if err := loadState(config, webhookStateFile, &d.state); err != nil {
+		slog.Warn("Failed to load webhook queue", "error", err)
+	}
+	events, _ := bus.Subscribe(0)
+	go d.queueEvents(bus, events)
+	return d
+}
+
+// queueEvents turns bus events into deliveries. If the subscription is
+// dropped for falling behind, it resubscribes and catches up from the bus
+// history. It ends when the bus is closed.
+func (d *WebhookDispatcher) queueEvents(bus *EventBus, events <-chan Event) {
+	var lastID uint64
+	for {
+		for event := range events {
+			d.enqueue(event)
+			lastID = event.ID
+		}
+		if bus.Closed() {
+			return
+		}
+		slog.Warn("Webhook dispatcher fell behind, catching up", "last_event_id", lastID)
+		events, _ = bus.Subscribe(lastID)
+	}
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (d *WebhookDispatcher) enqueue(event Event) {
+	var payload json.RawMessage
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queued := false
+	for i := range d.config.Webhooks {
+		webhook := &d.config.Webhooks[i]
+		if !webhook.Matches(event.Type) {
+			continue
+		}
+		if payload == nil {
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Warn("Failed to encode event for webhooks", "event", event.Type, "error", err)
+				return
+			}
+			payload = data
+		}
+		d.state.Pending = append(d.state.Pending, &WebhookDelivery{
+			ID:          newDeliveryID(),
+			Webhook:     webhook.Name,
+			EventType:   event.Type,
+			Payload:     payload,
+			State:       DeliveryPending,
+			CreatedAt:   now,
+			NextAttempt: now,
+		})
+		d.trimPending(webhook.Name)
+		queued = true
+	}
+	if !queued {
+		return
+	}
+
+	// Saved by Run, so a burst of events rewrites webhooks.json once
+	d.dirty = true
+	d.signal()
+}
+
+// trimPending drops the oldest pending deliveries of the webhook beyond
+// webhookMaxPending. d.mu must be held.
+func (d *WebhookDispatcher) trimPending(name string) {
+	count := 0
+	for _, delivery := range d.state.Pending {
+		if delivery.Webhook == name {
+			count++
+		}
+	}
+	drop := count - webhookMaxPending
+	if drop <= 0 {
+		return
+	}
+
+	kept := make([]*WebhookDelivery, 0, len(d.state.Pending)-drop)
+	for _, delivery := range d.state.Pending {
+		if delivery.Webhook == name && drop > 0 {
+			slog.Warn("Webhook queue full, dropping the oldest delivery", "webhook", name, "event", delivery.EventType,
+				"delivery_id", delivery.ID, "max_pending", webhookMaxPending)
+			drop--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	d.state.Pending = kept
+}
+
+func (d *WebhookDispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// saveState must be called with d.mu held.
+func (d *WebhookDispatcher) saveState() {
+	d.dirty = false
+	if err := saveState(d.config, webhookStateFile, d.state); err != nil {
+		slog.Warn("Failed to save webhook queue", "error", err)
+	}
+}
+
+// Close saves deliveries queued since the last save.
+func (d *WebhookDispatcher) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dirty {
+		d.saveState()
+	}
+}
+
+// Run delivers queued events until ctx is cancelled and the workers have
+// stopped. Undelivered events stay queued across restarts.
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	defer d.Close()
+	defer d.workers.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-d.wake:
+		}
+		timer.Reset(time.Until(d.deliverDue(ctx)))
+	}
+}
+
+// deliverDue saves the queue if needed, starts a worker for every idle
+// webhook with due deliveries and returns when the next delivery not taken
+// by a worker will be due.
+func (d *WebhookDispatcher) deliverDue(ctx context.Context) time.Time {
+	now := time.Now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.dirty {
+		d.saveState()
+	}
+
+	next := now.Add(webhookRetryMax)
+	due := make(map[string][]*WebhookDelivery)
+	for _, delivery := range d.state.Pending {
+		if d.busy[delivery.Webhook] {
+			continue // the worker wakes Run when it is done
+		}
+		at := delivery.NextAttempt
+		if blocked := d.blocked[delivery.Webhook]; blocked.After(at) {
+			at = blocked
+		}
+		if !at.After(now) {
+			due[delivery.Webhook] = append(due[delivery.Webhook], delivery)
+		} else if at.Before(next) {
+			next = at
+		}
+	}
+
+	for name, deliveries := range due {
+		d.busy[name] = true
+		delete(d.blocked, name)
+		d.workers.Add(1)
+		go d.deliver(ctx, name, deliveries)
+	}
+	return next
+}
+
+// deliver attempts the due deliveries of one webhook in order. After a
+// failed attempt the rest wait for that delivery's retry, so an endpoint
+// that is down gets one attempt per backoff period instead of one per
+// queued event.
+func (d *WebhookDispatcher) deliver(ctx context.Context, name string, deliveries []*WebhookDelivery) {
+	defer d.workers.Done()
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			break
+		}
+		if updated := d.attempt(ctx, delivery); updated != nil && updated.State == DeliveryPending {
+			d.mu.Lock()
+			d.blocked[name] = updated.NextAttempt
+			d.mu.Unlock()
+			break
+		}
+	}
+
+	d.mu.Lock()
+	delete(d.busy, name)
+	d.mu.Unlock()
+	d.signal()
+}
+
+// attempt sends the delivery once and returns it as updated, or nil if ctx
+// was cancelled during the attempt.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery *WebhookDelivery) *WebhookDelivery {
+	updated := *delivery
+	updated.Attempts++
+	updated.LastAttempt = time.Now()
+	updated.LastStatus = 0
+	updated.LastError = ""
+
+	webhook := d.webhook(delivery.Webhook)
+	if webhook == nil {
+		updated.LastError = "webhook is no longer configured"
+		updated.Attempts = webhookMaxAttempts
+	} else {
+		status, err := d.send(ctx, webhook, delivery)
+		if ctx.Err() != nil {
+			return nil // shutting down, try again after the restart
+		}
+		updated.LastStatus = status
+		if err != nil {
+			updated.LastError = err.Error()
+		}
+	}
+
+	switch {
+	case updated.LastError == "":
+		updated.State = DeliveryDelivered
+		updated.NextAttempt = time.Time{}
+	case updated.Attempts >= webhookMaxAttempts:
+		updated.State = DeliveryFailed
+		updated.NextAttempt = time.Time{}
+		slog.Warn("Webhook delivery failed, giving up", "webhook", updated.Webhook, "event", updated.EventType,
+			"delivery_id", updated.ID, "attempts", updated.Attempts, "error", updated.LastError)
+	default:
+		backoff := webhookRetryBase << (updated.Attempts - 1)
+		if backoff > webhookRetryMax || backoff <= 0 {
+			backoff = webhookRetryMax
+		}
+		updated.NextAttempt = updated.LastAttempt.Add(backoff)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, pending := range d.state.Pending {
+		if pending.ID != delivery.ID {
+			continue
+		}
+		if updated.State == DeliveryPending {
+			d.state.Pending[i] = &updated
+		} else {
+			d.state.Pending = append(d.state.Pending[:i:i], d.state.Pending[i+1:]...)
+			d.state.Log = append(d.state.Log, &updated)
+			if len(d.state.Log) > webhookLogSize {
+				d.state.Log = d.state.Log[len(d.state.Log)-webhookLogSize:]
+			}
+		}
+		break
+	}
+	d.saveState()
+	return &updated
+}
+
+func (d *WebhookDispatcher) webhook(name string) *WebhookConfig {
+	for i := range d.config.Webhooks {
+		if d.config.Webhooks[i].Name == name {
+			return &d.config.Webhooks[i]
+		}
+	}
+	return nil
+}
+
+// signPayload returns the X-Webhook-Signature value for body.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *WebhookDispatcher) send(ctx context.Context, webhook *WebhookConfig, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "wg-easy-go")
+	req.Header.Set("X-Webhook-Event", string(delivery.EventType))
+	req.Header.Set("X-Webhook-Delivery", delivery.ID)
+	req.Header.Set("X-Webhook-Signature", signPayload(webhook.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		// Drop the URL from the message, it may contain a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Deliveries returns the pending deliveries, soonest first, and the delivery
+// log, newest first.
+func (d *WebhookDispatcher) Deliveries() (pending, finished []WebhookDelivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending = make([]WebhookDelivery, 0, len(d.state.Pending))
+	for _, delivery := range d.state.Pending {
+		pending = append(pending, *delivery)
+	}
+	finished = make([]WebhookDelivery, 0, len(d.state.Log))
+	for i := len(d.state.Log) - 1; i >= 0; i-- {
+		finished = append(finished, *d.state.Log[i])
+	}
+	return pending, finished
+}
+
+// PendingCount returns the number of queued deliveries per webhook.
+func (d *WebhookDispatcher) PendingCount() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, delivery := range d.state.Pending {
+		counts[delivery.Webhook]++
+	}
+	return counts
+}
+
+// Retry makes a pending delivery due now, or queues a finished one again
+// with a fresh set of attempts.
+func (d *WebhookDispatcher) Retry(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	for i, delivery := range d.state.Pending {
+		if delivery.ID == id {
+			updated := *delivery
+			updated.NextAttempt = now
+			d.state.Pending[i] = &updated
+			delete(d.blocked, updated.Webhook)
+			d.saveState()
+			d.signal()
+			return nil
+		}
+	}
+	for i, delivery := range d.state.Log {
+		if delivery.ID == id {
+			updated := *delivery
+			updated.State = DeliveryPending
+			updated.Attempts = 0
+			updated.NextAttempt = now
+			d.state.Log = append(d.state.Log[:i:i], d.state.Log[i+1:]...)
+			d.state.Pending = append(d.state.Pending, &updated)
+			delete(d.blocked, updated.Webhook)
+			d.saveState()
+			d.signal()
+			return nil
+		}
+	}
+	return fmt.Errorf("delivery not found")
+}
+
+// SendTest queues a webhook.test event for the named webhook.
+func (d *WebhookDispatcher) SendTest(name, username string) error {
+	if d.webhook(name) == nil {
+		return fmt.Errorf("webhook not found")
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(Event{
+		Type: EventWebhookTest,
+		Time: now,
+		Data: map[string]string{"webhook": name, "requested_by": username},
+	})
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.state.Pending = append(d.state.Pending, &WebhookDelivery{
+		ID:          newDeliveryID(),
+		Webhook:     name,
+		EventType:   EventWebhookTest,
+		Payload:     payload,
+		State:       DeliveryPending,
+		CreatedAt:   now,
+		NextAttempt: now,
+	})
+	d.trimPending(name)
+	delete(d.blocked, name)
+	d.saveState()
+	d.signal()
+	return nil
+}
@@ -1,43 +1,110 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/curve25519"
 )
 
 type WireGuardClient struct {
-	ID         string `json:"id"`
-	Name       string `json:"name"`
-	PublicKey  string `json:"public_key"`
-	PrivateKey string `json:"private_key"`
-	AddressV4  string `json:"address_v4"`
-	AddressV6  string `json:"address_v6"`
-	CreatedAt  string `json:"created_at"`
-	Enabled    bool   `json:"enabled"`
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	PublicKey  string   `json:"public_key"`
+	PrivateKey string   `json:"private_key"`
+	AddressV4  string   `json:"address_v4"`
+	AddressV6  string   `json:"address_v6"`
+	CreatedAt  string   `json:"created_at"`
+	Enabled    bool     `json:"enabled"`
+	Owner      string   `json:"owner,omitempty"`       // username of a self-service user
+	AllowedIPs []string `json:"allowed_ips,omitempty"` // routed through the tunnel, default wg_allowed_ips
 }
 
+var (
+	errClientQuota        = errors.New("client quota reached")
+	errClientNameRequired = errors.New("name is required")
+	errInvalidAllowedIP   = errors.New("invalid allowed IP")
+)
+
+// clientsFile persists the clients, including their private keys, so owners,
+// AllowedIPs and disabled clients survive restarts.
+const clientsFile = "clients.json"
+
+const (
+	peerPollInterval = 10 * time.Second
+	// Peers with traffic handshake at least every two minutes
+	peerOnlineTimeout = 3 * time.Minute
+)
+
 type WireGuardManager struct {
-	config  *Config
-	clients map[string]*WireGuardClient
-	pf      *PortForwardServer
-	mu      sync.RWMutex
-	nextIP  int
+	config   *Config
+	settings *SettingsStore
+	clients  map[string]*WireGuardClient
+	pf       *PortForwardServer
+	events   *EventBus
+	mu       sync.RWMutex
+	nextIP   int
 }
 
-func NewWireGuardManager(config *Config) *WireGuardManager {
-	return &WireGuardManager{
-		config:  config,
-		clients: make(map[string]*WireGuardClient),
-		nextIP:  2, // Start from .2 (server is .1)
+// PeerStatus is the data of peer.* events.
+type PeerStatus struct {
+	Name          string    `json:"name"`
+	PublicKey     string    `json:"public_key"`
+	LastHandshake time.Time `json:"last_handshake,omitzero"`
+	Online        bool      `json:"online"`
+}
+
+func NewWireGuardManager(config *Config, settings *SettingsStore, events *EventBus) *WireGuardManager {
+	wm := &WireGuardManager{
+		config:   config,
+		settings: settings,
+		clients:  make(map[string]*WireGuardClient),
+		events:   events,
+		nextIP:   2, // Start from .2 (server is .1)
+	}
+	wm.loadClients()
+	return wm
+}
+
+func (wm *WireGuardManager) loadClients() {
+	var clients []*WireGuardClient
+	if err := loadState(wm.config, clientsFile, &clients); err != nil {
+		slog.Warn("Failed to load clients", "error", err)
+		return
+	}
+
+	for _, client := range clients {
+		wm.clients[client.ID] = client
+		if n, err := strconv.Atoi(strings.TrimPrefix(client.ID, "client-")); err == nil && n >= wm.nextIP {
+			wm.nextIP = n + 1
+		}
+	}
+	if len(clients) > 0 {
+		slog.Info("Loaded clients", "count", len(clients))
+	}
+}
+
+// saveClients must be called with wm.mu held.
+func (wm *WireGuardManager) saveClients() {
+	clients := make([]*WireGuardClient, 0, len(wm.clients))
+	for _, client := range wm.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	if err := saveState(wm.config, clientsFile, clients); err != nil {
+		slog.Warn("Failed to save clients", "error", err)
 	}
 }
 
@@ -45,6 +112,27 @@ func (wm *WireGuardManager) SetPortForwardServer(pf *PortForwardServer) {
 	wm.pf = pf
 }
 
+// clientIPv4 returns the client's VPN IPv4 address without prefix length
+// (e.g. "10.8.0.2/32" -> "10.8.0.2").
+func clientIPv4(client *WireGuardClient) string {
+	if ip, _, err := net.ParseCIDR(client.AddressV4); err == nil {
+		return ip.String()
+	}
+	return client.AddressV4
+}
+
+// publish reports a change of the client on the event bus, without its
+// private key.
+func (wm *WireGuardManager) publish(eventType EventType, client *WireGuardClient) {
+	wm.events.Publish(Event{
+		Type:     eventType,
+		ClientID: client.ID,
+		ClientIP: clientIPv4(client),
+		Owner:    client.Owner,
+		Data:     auditClient(client),
+	})
+}
+
 func generatePrivateKey() (string, error) {
 	var privateKey [32]byte
 	if _, err := rand.Read(privateKey[:]); err != nil {
@@ -68,10 +156,30 @@ func generatePublicKey(privateKey string) (string, error) {
 	return base64.StdEncoding.EncodeToString(publicKey[:]), nil
 }
 
-func (wm *WireGuardManager) CreateClient(name string) (*WireGuardClient, error) {
+// CreateClient creates a client owned by owner (may be empty) with its own
+// AllowedIPs (nil for the default). If maxOwned is positive, creation fails
+// once the owner already has that many clients.
+func (wm *WireGuardManager) CreateClient(name, owner string, allowedIPs []string, maxOwned int) (*WireGuardClient, error) {
+	allowedIPs, err := normalizeAllowedIPs(allowedIPs)
+	if err != nil {
+		return nil, err
+	}
+
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
 
+	if maxOwned > 0 {
+		owned := 0
+		for _, client := range wm.clients {
+			if client.Owner == owner {
+				owned++
+			}
+		}
+		if owned >= maxOwned {
+			return nil, fmt.Errorf("%w (%d)", errClientQuota, maxOwned)
+		}
+	}
+
 	privateKey, err := generatePrivateKey()
 	if err != nil {
 		return nil, err
@@ -99,6 +207,8 @@ func (wm *WireGuardManager) CreateClient(name string) (*WireGuardClient, error)
 		AddressV4:  addressV4,
 		AddressV6:  addressV6,
 		Enabled:    true,
+		Owner:      owner,
+		AllowedIPs: allowedIPs,
 	}
 
 	wm.clients[client.ID] = client
@@ -109,6 +219,8 @@ func (wm *WireGuardManager) CreateClient(name string) (*WireGuardClient, error)
 		delete(wm.clients, client.ID)
 		return nil, err
 	}
+	wm.saveClients()
+	wm.publish(EventClientCreated, client)
 
 	return client, nil
 }
@@ -127,19 +239,170 @@ func (wm *WireGuardManager) DeleteClient(id string) error {
 	}
 
 	// Clean up port forwards for this client
-	if wm.pf != nil {
-		// Extract IP from CIDR notation
-		clientIP := client.AddressV4
-		if ip, _, err := net.ParseCIDR(client.AddressV4); err == nil {
-			clientIP = ip.String()
+	wm.removeClientPortForwards(client, "client deleted")
+
+	delete(wm.clients, id)
+	wm.saveClients()
+	wm.publish(EventClientDeleted, client)
+	return nil
+}
+
+// ClientChange lists the client fields to change; nil fields are kept.
+type ClientChange struct {
+	Name       *string
+	Enabled    *bool
+	AllowedIPs *[]string // an empty list restores the default, wg_allowed_ips
+}
+
+// UpdateClient applies all fields of change or none: they are validated
+// first, and the client is only stored once its peer was added or removed.
+// Disabling a client also drops its port forwards.
+func (wm *WireGuardManager) UpdateClient(id string, change ClientChange) (*WireGuardClient, error) {
+	var name string
+	if change.Name != nil {
+		if name = strings.TrimSpace(*change.Name); name == "" {
+			return nil, errClientNameRequired
 		}
-		if err := wm.pf.RemoveAllClientMappings(clientIP); err != nil {
-			log.Printf("Warning: Failed to clean up port forwards for client %s: %v", id, err)
+	}
+	var allowedIPs []string
+	if change.AllowedIPs != nil {
+		var err error
+		if allowedIPs, err = normalizeAllowedIPs(*change.AllowedIPs); err != nil {
+			return nil, err
 		}
 	}
 
-	delete(wm.clients, id)
-	return nil
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	client, exists := wm.clients[id]
+	if !exists {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	toggled := change.Enabled != nil && *change.Enabled != client.Enabled
+	if toggled {
+		if *change.Enabled {
+			if err := wm.addPeer(client); err != nil {
+				return nil, err
+			}
+		} else {
+			if err := wm.removePeer(client); err != nil {
+				return nil, err
+			}
+			wm.removeClientPortForwards(client, "client disabled")
+		}
+	}
+
+	updated := *client
+	if change.Name != nil {
+		updated.Name = name
+	}
+	if change.AllowedIPs != nil {
+		updated.AllowedIPs = allowedIPs
+	}
+	if change.Enabled != nil {
+		updated.Enabled = *change.Enabled
+	}
+	wm.clients[id] = &updated
+	wm.saveClients()
+
+	if change.Name != nil || change.AllowedIPs != nil {
+		wm.publish(EventClientUpdated, &updated)
+	}
+	if toggled && updated.Enabled {
+		wm.publish(EventClientEnabled, &updated)
+	} else if toggled {
+		wm.publish(EventClientDisabled, &updated)
+	}
+	return &updated, nil
+}
+
+// SetClientEnabled adds or removes the client's peer while keeping the
+// client itself.
+func (wm *WireGuardManager) SetClientEnabled(id string, enabled bool) (*WireGuardClient, error) {
+	return wm.UpdateClient(id, ClientChange{Enabled: &enabled})
+}
+
+// normalizeAllowedIPs validates a client's AllowedIPs and returns them as
+// network addresses, or nil for an empty list.
+func normalizeAllowedIPs(allowedIPs []string) ([]string, error) {
+	if len(allowedIPs) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(allowedIPs))
+	for _, cidr := range allowedIPs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", errInvalidAllowedIP, cidr)
+		}
+		normalized = append(normalized, network.String())
+	}
+	return normalized, nil
+}
+
+// updateClient applies update to a copy of the client and stores the copy;
+// readers may still hold the old one.
+func (wm *WireGuardManager) updateClient(id string, update func(client *WireGuardClient) error) (*WireGuardClient, error) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	client, exists := wm.clients[id]
+	if !exists {
+		return nil, fmt.Errorf("client not found")
+	}
+
+	updated := *client
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	wm.clients[id] = &updated
+	wm.saveClients()
+	wm.publish(EventClientUpdated, &updated)
+	return &updated, nil
+}
+
+// RegenerateClientKeys gives the client a new key pair. Configs downloaded
+// before stop working.
+func (wm *WireGuardManager) RegenerateClientKeys(id string) (*WireGuardClient, error) {
+	privateKey, err := generatePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := generatePublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return wm.updateClient(id, func(client *WireGuardClient) error {
+		old := *client
+		client.PrivateKey = privateKey
+		client.PublicKey = publicKey
+		if !client.Enabled {
+			return nil
+		}
+
+		if err := wm.removePeer(&old); err != nil {
+			return err
+		}
+		if err := wm.addPeer(client); err != nil {
+			if restoreErr := wm.addPeer(&old); restoreErr != nil {
+				slog.Warn("Failed to restore peer", "client_id", old.ID, "client_ip", clientIPv4(&old), "error", restoreErr)
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+func (wm *WireGuardManager) removeClientPortForwards(client *WireGuardClient, reason string) {
+	if wm.pf == nil {
+		return
+	}
+
+	if err := wm.pf.RemoveAllClientMappings(clientIPv4(client), reason); err != nil {
+		slog.Warn("Failed to clean up port forwards", "client_id", client.ID, "client_ip", clientIPv4(client), "error", err)
+	}
 }
 
 func (wm *WireGuardManager) GetClients() []*WireGuardClient {
@@ -177,6 +440,7 @@ func (wm *WireGuardManager) addPeer(client *WireGuardClient) error {
 	if err != nil {
 		return fmt.Errorf("failed to add peer: %v - %s", err, string(output))
 	}
+	slog.Debug("Peer added", "client_id", client.ID, "client_ip", clientIPv4(client))
 
 	return wm.saveConfig()
 }
@@ -189,6 +453,7 @@ func (wm *WireGuardManager) removePeer(client *WireGuardClient) error {
 	if err != nil {
 		return fmt.Errorf("failed to remove peer: %v - %s", err, string(output))
 	}
+	slog.Debug("Peer removed", "client_id", client.ID, "client_ip", clientIPv4(client))
 
 	return wm.saveConfig()
 }
@@ -203,22 +468,128 @@ func (wm *WireGuardManager) saveConfig() error {
 }
 
 func (wm *WireGuardManager) GenerateClientConfig(client *WireGuardClient) string {
-	return fmt.Sprintf(`[Interface]
-PrivateKey = %s
-Address = %s, %s
-DNS = 1.1.1.1, 2606:4700:4700::1111
+	config := wm.settings.Current()
+	allowedIPs := client.AllowedIPs
+	if len(allowedIPs) == 0 {
+		allowedIPs = config.WgAllowedIPs
+	}
 
-[Peer]
-PublicKey = %s
-Endpoint = %s
-AllowedIPs = 0.0.0.0/0, ::/0
-PersistentKeepalive = 25
-`,
-		client.PrivateKey,
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Interface]\nPrivateKey = %s\n", client.PrivateKey)
+	fmt.Fprintf(&b, "Address = %s, %s\n",
 		strings.TrimSuffix(client.AddressV4, "/32")+"/32",
-		strings.TrimSuffix(client.AddressV6, "/128")+"/128",
-		wm.getServerPublicKey(),
-		wm.config.WgEndpoint)
+		strings.TrimSuffix(client.AddressV6, "/128")+"/128")
+	fmt.Fprintf(&b, "DNS = %s\n", strings.Join(config.WgDNS, ", "))
+	if config.WgMTU > 0 {
+		fmt.Fprintf(&b, "MTU = %d\n", config.WgMTU)
+	}
+	fmt.Fprintf(&b, "\n[Peer]\nPublicKey = %s\n", wm.getServerPublicKey())
+	fmt.Fprintf(&b, "Endpoint = %s\n", config.WgEndpoint)
+	fmt.Fprintf(&b, "AllowedIPs = %s\n", strings.Join(allowedIPs, ", "))
+	if config.WgPersistentKeepalive > 0 {
+		fmt.Fprintf(&b, "PersistentKeepalive = %d\n", config.WgPersistentKeepalive)
+	}
+	return b.String()
+}
+
+// MonitorPeers polls the peers' statistics until ctx is cancelled and
+// publishes handshakes and online/offline changes. The first poll only
+// records the current state.
+func (wm *WireGuardManager) MonitorPeers(ctx context.Context) {
+	ticker := time.NewTicker(peerPollInterval)
+	defer ticker.Stop()
+
+	peers := make(map[string]PeerStatus) // by public key
+	for {
+		wm.pollPeers(peers)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (wm *WireGuardManager) pollPeers(peers map[string]PeerStatus) {
+	stats, err := wm.peerStats()
+	if err != nil {
+		return // interface down, nothing to report
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, client := range wm.GetClients() {
+		last := stats[client.PublicKey].LastHandshake
+		status := PeerStatus{
+			Name:          client.Name,
+			PublicKey:     client.PublicKey,
+			LastHandshake: last,
+			Online:        !last.IsZero() && now.Sub(last) < peerOnlineTimeout,
+		}
+		previous, known := peers[client.PublicKey]
+		peers[client.PublicKey] = status
+		seen[client.PublicKey] = true
+		if !known {
+			continue
+		}
+
+		event := Event{ClientID: client.ID, ClientIP: clientIPv4(client), Owner: client.Owner, Data: status}
+		if last.After(previous.LastHandshake) {
+			event.Type = EventPeerHandshake
+			wm.events.Publish(event)
+		}
+		if status.Online != previous.Online {
+			event.Type = EventPeerOffline
+			if status.Online {
+				event.Type = EventPeerOnline
+			}
+			slog.Info("Peer status changed", "client_id", client.ID, "client_ip", event.ClientIP, "online", status.Online)
+			wm.events.Publish(event)
+		}
+	}
+
+	for key := range peers {
+		if !seen[key] {
+			delete(peers, key)
+		}
+	}
+}
+
+// PeerStats are a peer's transfer counters and last handshake as reported
+// by the kernel.
+type PeerStats struct {
+	LastHandshake time.Time
+	ReceiveBytes  uint64
+	TransmitBytes uint64
+}
+
+// peerStats returns the statistics of each peer by public key, read from
+// `wg show <interface> dump`. LastHandshake is zero for peers without one.
+func (wm *WireGuardManager) peerStats() (map[string]PeerStats, error) {
+	output, err := exec.Command("wg", "show", wm.config.WgInterface, "dump").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	// The first line describes the interface; peer lines are: public key,
+	// preshared key, endpoint, allowed IPs, latest handshake, rx, tx,
+	// persistent keepalive.
+	stats := make(map[string]PeerStats)
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 8 {
+			continue
+		}
+		var peer PeerStats
+		if seconds, err := strconv.ParseInt(fields[4], 10, 64); err == nil && seconds > 0 {
+			peer.LastHandshake = time.Unix(seconds, 0)
+		}
+		peer.ReceiveBytes, _ = strconv.ParseUint(fields[5], 10, 64)
+		peer.TransmitBytes, _ = strconv.ParseUint(fields[6], 10, 64)
+		stats[fields[0]] = peer
+	}
+	return stats, nil
 }
 
 func (wm *WireGuardManager) getServerPublicKey() string {
@@ -270,3 +641,29 @@ PostDown = iptables -D FORWARD -i %%i -j ACCEPT; iptables -t nat -D POSTROUTING
 
 	return nil
 }
+
+// CheckInterface reports whether the WireGuard interface exists and is up.
+func (wm *WireGuardManager) CheckInterface() error {
+	iface, err := net.InterfaceByName(wm.config.WgInterface)
+	if err != nil {
+		return fmt.Errorf("interface %s not found: %v", wm.config.WgInterface, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return fmt.Errorf("interface %s is down", wm.config.WgInterface)
+	}
+	return nil
+}
+
+// Shutdown brings the WireGuard interface down when shutdown_remove_interface
+// is set. Otherwise the interface and its peers keep running.
+func (wm *WireGuardManager) Shutdown() {
+	if !wm.config.ShutdownRemoveInterface {
+		return
+	}
+
+	cmd := exec.Command("wg-quick", "down", wm.config.WgInterface)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		slog.Warn("Failed to bring down interface", "interface", wm.config.WgInterface,
+			"error", err, "output", strings.TrimSpace(string(output)))
+	}
+}